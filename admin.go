@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/adk/session"
+)
+
+type sessionInfo struct {
+	Name         string    `json:"name"`
+	LastActivity time.Time `json:"last_activity"`
+	Turns        int       `json:"turns"`
+}
+
+// registerAdmin adds the administrative endpoints to mux. All of them
+// require an "Authorization: Bearer <token>" header.
+// checkModel, if not nil, returns an error for model names that cannot be
+// used.
+func registerAdmin(mux *http.ServeMux, token string, sessionService session.Service, draining *atomic.Bool, checkModel func(ctx context.Context, name string) error) {
+	mux.Handle("POST /admin/drain", requireToken(token, func(w http.ResponseWriter, r *http.Request) {
+		draining.Store(true)
+		slog.Warn("draining, new chat requests will be rejected")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	mux.Handle("POST /admin/resume", requireToken(token, func(w http.ResponseWriter, r *http.Request) {
+		draining.Store(false)
+		slog.Info("resumed accepting chat requests")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	mux.Handle("GET /admin/sessions", requireToken(token, func(w http.ResponseWriter, r *http.Request) {
+		list, err := sessionService.List(r.Context(), &session.ListRequest{AppName: appName})
+		if err != nil {
+			slog.Error("failed to list sessions", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal", "failed to list sessions")
+			return
+		}
+
+		infos := make([]sessionInfo, 0, len(list.Sessions))
+		for _, s := range list.Sessions {
+			// List does not return events, so fetch each session to count turns.
+			resp, err := sessionService.Get(r.Context(), &session.GetRequest{
+				AppName:   appName,
+				UserID:    s.UserID(),
+				SessionID: s.ID(),
+			})
+			if err != nil {
+				// The session may have been deleted since it was listed.
+				continue
+			}
+			info := sessionInfo{
+				Name:         resp.Session.ID(),
+				LastActivity: resp.Session.LastUpdateTime(),
+			}
+			for event := range resp.Session.Events().All() {
+				if event.Author == "user" {
+					info.Turns++
+				}
+			}
+			infos = append(infos, info)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+	}))
+
+	mux.Handle("DELETE /admin/sessions/{name}", requireToken(token, func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		_, err := sessionService.Get(r.Context(), &session.GetRequest{
+			AppName:   appName,
+			UserID:    name,
+			SessionID: name,
+		})
+		if err != nil {
+			writeError(w, http.StatusNotFound, "not_found", "session not found")
+			return
+		}
+		err = sessionService.Delete(r.Context(), &session.DeleteRequest{
+			AppName:   appName,
+			UserID:    name,
+			SessionID: name,
+		})
+		if err != nil {
+			slog.Error("failed to delete session", "session_id", name, "error", err)
+			writeError(w, http.StatusInternalServerError, "internal", "failed to delete session")
+			return
+		}
+		slog.Info("session deleted", "session_id", name)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	// The session's model is kept in its state, so it applies from the
+	// next message on and the history is unchanged. An empty model goes
+	// back to the default.
+	mux.Handle("POST /admin/sessions/{name}/model", requireToken(token, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_json", "body must be a JSON object with a model")
+			return
+		}
+		if req.Model != "" && checkModel != nil {
+			if err := checkModel(r.Context(), req.Model); err != nil {
+				slog.Warn("rejected session model", "model", req.Model, "error", err)
+				writeError(w, http.StatusBadRequest, "unknown_model", "model "+req.Model+" is not available")
+				return
+			}
+		}
+
+		name := r.PathValue("name")
+		resp, err := sessionService.Get(r.Context(), &session.GetRequest{
+			AppName:   appName,
+			UserID:    name,
+			SessionID: name,
+		})
+		if err != nil {
+			writeError(w, http.StatusNotFound, "not_found", "session not found")
+			return
+		}
+		event := session.NewEvent("admin-" + rand.Text())
+		event.Author = "admin"
+		event.Actions.StateDelta[sessionModelKey] = req.Model
+		if err := sessionService.AppendEvent(r.Context(), resp.Session, event); err != nil {
+			slog.Error("failed to set session model", "session_id", name, "error", err)
+			writeError(w, http.StatusInternalServerError, "internal", "failed to set session model")
+			return
+		}
+		slog.Info("session model changed", "session_id", name, "model", req.Model)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+// requireToken wraps next so that it is only called when the request
+// carries the expected bearer token.
+func requireToken(token string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "unauthorized", "a valid bearer token is required")
+			return
+		}
+		next(w, r)
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testAdminToken = "secret"
+
+// newTestAdmin returns a chat handler and the admin endpoints for its
+// sessions.
+func newTestAdmin(t *testing.T) (*testChat, *http.ServeMux, *atomic.Bool) {
+	t.Helper()
+	c := newTestChat(t, echoModel{})
+	mux := http.NewServeMux()
+	var draining atomic.Bool
+	registerAdmin(mux, testAdminToken, c.sessionService, &draining, nil)
+	return c, mux, &draining
+}
+
+// adminRequest sends a request with the admin token to mux.
+func adminRequest(mux http.Handler, method, path, token string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, body)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	_, mux, _ := newTestAdmin(t)
+	tests := []struct {
+		method, path, token string
+	}{
+		{http.MethodGet, "/admin/sessions", ""},
+		{http.MethodGet, "/admin/sessions", "wrong"},
+		{http.MethodDelete, "/admin/sessions/a", ""},
+		{http.MethodPost, "/admin/drain", "wrong"},
+		{http.MethodPost, "/admin/resume", ""},
+		{http.MethodPost, "/admin/sessions/a/model", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := adminRequest(mux, tt.method, tt.path, tt.token, strings.NewReader("{}"))
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want 401", rec.Code)
+			}
+		})
+	}
+}
+
+// listSessions returns the sessions reported by GET /admin/sessions.
+func listSessions(t *testing.T, mux http.Handler) []sessionInfo {
+	t.Helper()
+	rec := adminRequest(mux, http.MethodGet, "/admin/sessions", testAdminToken, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: status = %d: %s", rec.Code, rec.Body)
+	}
+	var infos []sessionInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &infos); err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(infos, func(a, b sessionInfo) int { return strings.Compare(a.Name, b.Name) })
+	return infos
+}
+
+func TestAdminListAndDeleteSessions(t *testing.T) {
+	c, mux, _ := newTestAdmin(t)
+	if infos := listSessions(t, mux); len(infos) != 0 {
+		t.Fatalf("sessions before any chat = %v, want none", infos)
+	}
+	for _, node := range []string{"a", "a", "b"} {
+		if rec := c.get(t, nil, "msg", "hi", "node_id", node); rec.Code != http.StatusOK {
+			t.Fatalf("chat: status = %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	infos := listSessions(t, mux)
+	if len(infos) != 2 || infos[0].Name != "a" || infos[0].Turns != 2 || infos[1].Name != "b" || infos[1].Turns != 1 {
+		t.Fatalf("sessions = %+v, want a with 2 turns and b with 1", infos)
+	}
+	if infos[0].LastActivity.IsZero() {
+		t.Error("last activity is not set")
+	}
+
+	tests := []struct {
+		name string
+		want int
+	}{
+		{"a", http.StatusNoContent},
+		{"a", http.StatusNotFound},
+		{"missing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := adminRequest(mux, http.MethodDelete, "/admin/sessions/"+tt.name, testAdminToken, nil); rec.Code != tt.want {
+			t.Errorf("delete %s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+	if infos := listSessions(t, mux); len(infos) != 1 || infos[0].Name != "b" {
+		t.Errorf("sessions after delete = %+v, want only b", infos)
+	}
+}
+
+func TestAdminSessionModel(t *testing.T) {
+	llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("ok")}}
+	c := newTestChat(t, llm)
+	mux := http.NewServeMux()
+	var draining atomic.Bool
+	registerAdmin(mux, testAdminToken, c.sessionService, &draining, func(ctx context.Context, name string) error {
+		if name != "gemini-new" {
+			return errors.New("not found")
+		}
+		return nil
+	})
+
+	steps := []struct {
+		session, body string
+		status        int
+		wantModel     string // the model of the next message to a
+	}{
+		{"a", `{"model":"gemini-new"}`, http.StatusNoContent, "gemini-new"},
+		{"a", `{"model":"gemini-unknown"}`, http.StatusBadRequest, "gemini-new"},
+		{"a", `not json`, http.StatusBadRequest, "gemini-new"},
+		{"missing", `{"model":"gemini-new"}`, http.StatusNotFound, "gemini-new"},
+		{"a", `{"model":""}`, http.StatusNoContent, ""},
+	}
+	if rec := c.get(t, nil, "msg", "hi", "node_id", "a"); rec.Code != http.StatusOK {
+		t.Fatalf("chat: status = %d: %s", rec.Code, rec.Body)
+	}
+	defaultModel := llm.calls[0].Model
+	for i, step := range steps {
+		rec := adminRequest(mux, http.MethodPost, "/admin/sessions/"+step.session+"/model", testAdminToken, strings.NewReader(step.body))
+		if rec.Code != step.status {
+			t.Errorf("step %d: status = %d, want %d: %s", i+1, rec.Code, step.status, rec.Body)
+		}
+		if rec := c.get(t, nil, "msg", "hi", "node_id", "a"); rec.Code != http.StatusOK {
+			t.Fatalf("chat: status = %d: %s", rec.Code, rec.Body)
+		}
+		call := llm.calls[len(llm.calls)-1]
+		want := cmp.Or(step.wantModel, defaultModel)
+		if call.Model != want {
+			t.Errorf("step %d: model = %q, want %q", i+1, call.Model, want)
+		}
+		// The history is kept across the switch.
+		if n := len(call.Contents); n != 2*(i+1)+1 {
+			t.Errorf("step %d: sent %d contents, want %d", i+1, n, 2*(i+1)+1)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// alternativesKey is the LLMResponse custom metadata key holding the text
+// of every candidate when the model wrote more than one. The ADK keeps only
+// the first candidate, which is what the session history records.
+const alternativesKey = "alternatives"
+
+// defaultAlternatives is the number of candidates asked for by
+// alternatives=true without a candidates parameter.
+const defaultAlternatives = 3
+
+// alternatives are the usable candidates of one model response, in order.
+// Filtered counts the candidates left out because they were empty or
+// blocked.
+type alternatives struct {
+	Texts    []string
+	Filtered int
+}
+
+// candidateAlternatives gathers the text of each candidate that finished
+// normally and has some.
+func candidateAlternatives(candidates []*genai.Candidate) alternatives {
+	var a alternatives
+	for _, c := range candidates {
+		if c == nil || c.Content == nil {
+			a.Filtered++
+			continue
+		}
+		switch c.FinishReason {
+		case "", genai.FinishReasonStop, genai.FinishReasonMaxTokens:
+		default:
+			a.Filtered++
+			continue
+		}
+		var b strings.Builder
+		for _, p := range c.Content.Parts {
+			if p != nil && !p.Thought {
+				b.WriteString(p.Text)
+			}
+		}
+		if strings.TrimSpace(b.String()) == "" {
+			a.Filtered++
+			continue
+		}
+		a.Texts = append(a.Texts, b.String())
+	}
+	return a
+}
+
+// writeAlternatives writes the alternative replies as JSON.
+func writeAlternatives(w http.ResponseWriter, texts []string, filtered int, modelVersion string) error {
+	if texts == nil {
+		texts = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(struct {
+		Alternatives []string `json:"alternatives"`
+		Filtered     int      `json:"filtered"`
+		Model        string   `json:"model,omitempty"`
+	}{texts, filtered, modelVersion})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+func TestCandidateAlternatives(t *testing.T) {
+	text := func(reason genai.FinishReason, parts ...*genai.Part) *genai.Candidate {
+		return &genai.Candidate{Content: &genai.Content{Role: genai.RoleModel, Parts: parts}, FinishReason: reason}
+	}
+	tests := []struct {
+		name       string
+		candidates []*genai.Candidate
+		want       []string
+		filtered   int
+	}{
+		{"all usable", []*genai.Candidate{text(genai.FinishReasonStop, genai.NewPartFromText("a")), text(genai.FinishReasonStop, genai.NewPartFromText("b"))}, []string{"a", "b"}, 0},
+		{"parts joined", []*genai.Candidate{text(genai.FinishReasonStop, genai.NewPartFromText("a"), genai.NewPartFromText("b"))}, []string{"ab"}, 0},
+		{"cut off by max tokens", []*genai.Candidate{text(genai.FinishReasonMaxTokens, genai.NewPartFromText("a"))}, []string{"a"}, 0},
+		{"blocked", []*genai.Candidate{text(genai.FinishReasonStop, genai.NewPartFromText("a")), text(genai.FinishReasonSafety, genai.NewPartFromText("b"))}, []string{"a"}, 1},
+		{"no content", []*genai.Candidate{{FinishReason: genai.FinishReasonSafety}, nil}, nil, 2},
+		{"white space", []*genai.Candidate{text(genai.FinishReasonStop, genai.NewPartFromText(" \n"))}, nil, 1},
+		{"thought only", []*genai.Candidate{text(genai.FinishReasonStop, &genai.Part{Text: "hmm", Thought: true})}, nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := candidateAlternatives(tt.candidates)
+			if !slices.Equal(a.Texts, tt.want) || a.Filtered != tt.filtered {
+				t.Errorf("got %q with %d filtered, want %q with %d", a.Texts, a.Filtered, tt.want, tt.filtered)
+			}
+		})
+	}
+}
+
+// geminiCandidates is a response with a candidate for each text. An empty
+// text is a candidate blocked for safety.
+func geminiCandidates(texts ...string) map[string]any {
+	var candidates []any
+	for _, text := range texts {
+		if text == "" {
+			candidates = append(candidates, map[string]any{"finishReason": "SAFETY"})
+			continue
+		}
+		candidates = append(candidates, map[string]any{
+			"content":      map[string]any{"role": "model", "parts": []any{map[string]any{"text": text}}},
+			"finishReason": "STOP",
+		})
+	}
+	return map[string]any{"candidates": candidates, "modelVersion": "fake-1"}
+}
+
+func TestChatAlternatives(t *testing.T) {
+	type result struct {
+		Alternatives []string `json:"alternatives"`
+		Filtered     int      `json:"filtered"`
+		Model        string   `json:"model"`
+	}
+	tests := []struct {
+		name           string
+		query          string
+		body           map[string]any
+		status         int
+		want           result
+		wantCandidates float64 // candidateCount sent to the model
+	}{
+		{"one blocked", "alternatives=true", geminiCandidates("Model: one", "", "three"), http.StatusOK, result{[]string{"one", "three"}, 1, "fake-1"}, 3},
+		{"candidates parameter", "alternatives=true&candidates=2", geminiCandidates("one", "two"), http.StatusOK, result{[]string{"one", "two"}, 0, "fake-1"}, 2},
+		{"all blocked", "alternatives=true", geminiCandidates("one", "", ""), http.StatusOK, result{[]string{"one"}, 2, "fake-1"}, 3},
+		{"single candidate", "alternatives=true&candidates=1", geminiText("only"), http.StatusOK, result{[]string{"only"}, 0, "fake-1"}, 1},
+		{"invalid", "alternatives=maybe", nil, http.StatusBadRequest, result{}, 0},
+		{"with format", "alternatives=true&format=code", nil, http.StatusBadRequest, result{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newFakeGemini(t)
+			g.respond = func(fakeGeminiRequest) (int, any) { return http.StatusOK, tt.body }
+			cfg, _ := testConfig(t, "-api-keys", "k1", "-base-url", g.URL+"/", "-strip-patterns", `^Model:\s*`)
+			llm, err := buildModel(t.Context(), cfg, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sessionService := session.InMemoryService()
+			chat := chatHandler(cfg, newTestRunner(t, cfg, sessionService, llm), sessionService, nil, nil, nil, nil, nil)
+			rec := httptest.NewRecorder()
+			chat.ServeHTTP(rec, httptest.NewRequest("GET", "/?node_id=n1&msg=hi&"+tt.query, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				if code := decodeError(t, rec); code != "invalid_parameter" {
+					t.Errorf("code = %q, want invalid_parameter", code)
+				}
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var got result
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got.Alternatives, tt.want.Alternatives) || got.Filtered != tt.want.Filtered || got.Model != tt.want.Model {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			calls := g.calls()
+			genCfg, _ := calls[0].Body["generationConfig"].(map[string]any)
+			if n := genCfg["candidateCount"]; n != tt.wantCandidates {
+				t.Errorf("candidateCount = %v, want %v", n, tt.wantCandidates)
+			}
+		})
+	}
+}
+
+// TestChatAlternativesOneCandidate checks that a model with no
+// alternatives, such as an OpenAI compatible one, answers with its reply.
+func TestChatAlternativesOneCandidate(t *testing.T) {
+	c := newTestChat(t, &fakeModel{name: "fake", replies: []fakeReply{textReply("hello")}})
+	rec := c.get(t, nil, "msg", "hi", "alternatives", "true")
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"alternatives":["hello"],"filtered":0}`+"\n" {
+		t.Errorf("got %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// sessionBackoff turns away a session's messages for a while after it has
+// failed upstream threshold times in a row, so that one session whose
+// history the model keeps rejecting cannot use up the quota. The wait
+// starts at base and doubles with each further failure, up to max. A
+// successful turn resets it.
+type sessionBackoff struct {
+	threshold int
+	base      time.Duration
+	max       time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*backoffState
+}
+
+type backoffState struct {
+	failures int
+	until    time.Time
+}
+
+func newSessionBackoff(threshold int, base, max time.Duration) *sessionBackoff {
+	return &sessionBackoff{
+		threshold: threshold,
+		base:      base,
+		max:       max,
+		sessions:  make(map[string]*backoffState),
+	}
+}
+
+// wait returns how long the session must wait before sending another
+// message, or zero if it may send one now.
+func (b *sessionBackoff) wait(sessionID string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.sessions[sessionID]
+	if !ok {
+		return 0
+	}
+	return max(time.Until(s.until), 0)
+}
+
+// failure records an upstream failure for the session.
+func (b *sessionBackoff) failure(sessionID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Forget sessions that have not failed for a long time.
+	now := time.Now()
+	for id, s := range b.sessions {
+		if now.Sub(s.until) > b.max {
+			delete(b.sessions, id)
+		}
+	}
+
+	s, ok := b.sessions[sessionID]
+	if !ok {
+		s = &backoffState{until: now}
+		b.sessions[sessionID] = s
+	}
+	s.failures++
+	if s.failures < b.threshold {
+		return
+	}
+	d := b.base
+	for range s.failures - b.threshold {
+		if d >= b.max {
+			break
+		}
+		d *= 2
+	}
+	d = min(d, b.max)
+	s.until = now.Add(d)
+	slog.Warn("session failing repeatedly, backing off", "session_id", sessionID, "failures", s.failures, "backoff", d)
+}
+
+// success resets the session's failures.
+func (b *sessionBackoff) success(sessionID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.sessions, sessionID)
+}
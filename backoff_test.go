@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+)
+
+func TestSessionBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		success  bool
+		want     time.Duration // approximate wait after failures
+	}{
+		{"no failures", 0, false, 0},
+		{"below threshold", 2, false, 0},
+		{"at threshold", 3, false, time.Second},
+		{"doubles", 4, false, 2 * time.Second},
+		{"doubles again", 5, false, 4 * time.Second},
+		{"capped", 10, false, 5 * time.Second},
+		{"success resets", 5, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newSessionBackoff(3, time.Second, 5*time.Second)
+			for range tt.failures {
+				b.failure("s")
+			}
+			if tt.success {
+				b.success("s")
+			}
+			got := b.wait("s")
+			if got > tt.want || got < tt.want-100*time.Millisecond {
+				t.Errorf("wait = %v, want about %v", got, tt.want)
+			}
+			if other := b.wait("other"); other != 0 {
+				t.Errorf("wait for another session = %v, want 0", other)
+			}
+		})
+	}
+}
+
+// TestChatSessionBackoff fails a session's messages until it is backed
+// off, then checks that a success after the wait resets it.
+func TestChatSessionBackoff(t *testing.T) {
+	var calls atomic.Int32
+	llm := funcModel(func(req *model.LLMRequest) fakeReply {
+		calls.Add(1)
+		if strings.HasPrefix(lastUserText(req.Contents), "fail") {
+			return fakeReply{err: errors.New("history rejected")}
+		}
+		return textReply("ok")
+	})
+	cfg, _ := testConfig(t, "-dry-run")
+	sessionService := session.InMemoryService()
+	backoff := newSessionBackoff(2, 50*time.Millisecond, time.Minute)
+	chat := chatHandler(cfg, newTestRunner(t, cfg, sessionService, llm), sessionService, nil, nil, nil, nil, backoff)
+
+	steps := []struct {
+		node   string
+		msg    string
+		sleep  time.Duration // before the message
+		status int
+		called bool
+	}{
+		{"n1", "fail", 0, http.StatusInternalServerError, true},
+		{"n1", "fail", 0, http.StatusInternalServerError, true},
+		{"n1", "hi", 0, http.StatusTooManyRequests, false},
+		{"n2", "hi", 0, http.StatusOK, true},
+		{"n1", "hi", 60 * time.Millisecond, http.StatusOK, true},
+		{"n1", "fail", 0, http.StatusInternalServerError, true},
+		{"n1", "hi", 0, http.StatusOK, true},
+	}
+	for i, step := range steps {
+		time.Sleep(step.sleep)
+		before := calls.Load()
+		rec := httptest.NewRecorder()
+		chat.ServeHTTP(rec, httptest.NewRequest("GET", "/?node_id="+step.node+"&msg="+step.msg, nil))
+		if rec.Code != step.status {
+			t.Fatalf("step %d: status = %d, want %d: %s", i+1, rec.Code, step.status, rec.Body)
+		}
+		if called := calls.Load() > before; called != step.called {
+			t.Errorf("step %d: model called %v, want %v", i+1, called, step.called)
+		}
+		if rec.Code == http.StatusTooManyRequests {
+			if code := decodeError(t, rec); code != "session_backoff" {
+				t.Errorf("step %d: code = %q, want session_backoff", i+1, code)
+			}
+			if got := rec.Header().Get("Retry-After"); got != "1" {
+				t.Errorf("step %d: Retry-After = %q, want 1", i+1, got)
+			}
+		}
+	}
+}
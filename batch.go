@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// maxBatchItems bounds the number of prompts accepted by /batch.
+const maxBatchItems = 100
+
+type batchResult struct {
+	Text  string    `json:"text,omitempty"`
+	Model string    `json:"model,omitempty"`
+	Error *apiError `json:"error,omitempty"`
+}
+
+// batchHandler runs each prompt in a JSON array as an independent
+// single-turn conversation, at most -batch-concurrency at a time, and
+// returns the results in input order.
+func batchHandler(cfg *config, run *runner.Runner, sessionService session.Service, blocked blocklist) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var prompts []string
+		if err := json.NewDecoder(r.Body).Decode(&prompts); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_json", "body must be a JSON array of strings")
+			return
+		}
+		if len(prompts) > maxBatchItems {
+			writeError(w, http.StatusRequestEntityTooLarge, "too_many_items", "at most "+strconv.Itoa(maxBatchItems)+" prompts are allowed")
+			return
+		}
+
+		ctx := r.Context()
+		results := make([]batchResult, len(prompts))
+		sem := make(chan struct{}, max(cfg.BatchConcurrency, 1))
+		var wg sync.WaitGroup
+		for i, msg := range prompts {
+			if msg == "" {
+				results[i].Error = &apiError{http.StatusBadRequest, "missing_msg", "prompt is empty"}
+				continue
+			}
+			if e := checkPrompt(msg, cfg.MaxInput, blocked); e != nil {
+				results[i].Error = e
+				continue
+			}
+
+			wg.Go(func() {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				// Each prompt gets a throwaway session so no history is shared.
+				id := "batch-" + rand.Text()
+				defer sessionService.Delete(ctx, &session.DeleteRequest{AppName: appName, UserID: id, SessionID: id})
+
+				content := genai.NewContentFromText(msg, genai.RoleUser)
+				rep, err := collectReply(run.Run(ctx, id, id, content, agent.RunConfig{}))
+				if ctx.Err() != nil {
+					// The client went away; the results are not sent.
+					return
+				}
+				if errors.Is(err, errCircuitOpen) {
+					results[i].Error = &apiError{http.StatusServiceUnavailable, "unavailable", "the AI service is temporarily unavailable"}
+					return
+				}
+				var blockedErr *promptBlockedError
+				if errors.As(err, &blockedErr) {
+					results[i].Error = blockedErr.apiError()
+					return
+				}
+				if err != nil {
+					slog.Error("batch item failed", "index", i, "error", err)
+					results[i].Error = &apiError{http.StatusInternalServerError, "upstream_error", "failed to get response from AI"}
+					return
+				}
+				recordUsage(ctx, rep)
+				results[i].Text = cfg.Filter.apply(rep)
+				results[i].Model = rep.ModelVersion
+			})
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			slog.Debug("batch canceled", "error", ctx.Err())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+func TestBatch(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	llm := funcModel(func(req *model.LLMRequest) fakeReply {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		if len(req.Contents) != 1 {
+			return fakeReply{err: genai.APIError{Code: 400, Message: "history was shared"}}
+		}
+		msg := lastUserText(req.Contents)
+		if strings.HasPrefix(msg, "fail") {
+			return fakeReply{err: genai.APIError{Code: 500}}
+		}
+		return textReply("re: " + msg)
+	})
+	cfg, _ := testConfig(t, "-dry-run", "-batch-concurrency", "2", "-max-input-bytes", "10")
+	sessionService := session.InMemoryService()
+	h := batchHandler(cfg, newTestRunner(t, cfg, sessionService, llm), sessionService, nil)
+
+	body := `["a","fail 1","","b","this is too long","c","fail 2"]`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var results []batchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ text, code string }{
+		{"re: a", ""},
+		{"", "upstream_error"},
+		{"", "missing_msg"},
+		{"re: b", ""},
+		{"", "input_too_large"},
+		{"re: c", ""},
+		{"", "upstream_error"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, w := range want {
+		got := results[i]
+		var code string
+		if got.Error != nil {
+			code = got.Error.Code
+		}
+		if got.Text != w.text || code != w.code {
+			t.Errorf("result %d = %q, error %q; want %q, error %q", i, got.Text, code, w.text, w.code)
+		}
+	}
+	if m := maxRunning.Load(); m > 2 {
+		t.Errorf("%d prompts ran at once, want at most 2", m)
+	}
+
+	list, err := sessionService.List(t.Context(), &session.ListRequest{AppName: appName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Sessions) != 0 {
+		t.Errorf("%d batch sessions were left behind", len(list.Sessions))
+	}
+}
+
+func TestBatchBadRequest(t *testing.T) {
+	cfg, _ := testConfig(t, "-dry-run", "-batch-concurrency", "2", "-max-input-bytes", "0")
+	sessionService := session.InMemoryService()
+	h := batchHandler(cfg, newTestRunner(t, cfg, sessionService, echoModel{}), sessionService, nil)
+	tests := []struct {
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{`{"msg":"hi"}`, http.StatusBadRequest, "invalid_json"},
+		{`[1, 2]`, http.StatusBadRequest, "invalid_json"},
+		{"[" + strings.Repeat(`"x",`, maxBatchItems) + `"x"]`, http.StatusRequestEntityTooLarge, "too_many_items"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(tt.body)))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+		}
+		if code := decodeError(t, rec); code != tt.wantCode {
+			t.Errorf("code = %q, want %q", code, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// blocklist is a set of patterns that inbound prompts must not match.
+type blocklist []*regexp.Regexp
+
+// loadBlocklist reads one regular expression per line from path. Blank lines
+// and lines starting with # are ignored. Patterns are case-insensitive.
+func loadBlocklist(path string) (blocklist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var b blocklist
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		re, err := regexp.Compile("(?i)" + text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		b = append(b, re)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// match returns the first pattern that matches msg, or nil.
+func (b blocklist) match(msg string) *regexp.Regexp {
+	for _, re := range b {
+		if re.MatchString(msg) {
+			return re
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeBlocklist writes the lines to a blocklist file and returns its path.
+func writeBlocklist(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBlocklist(t *testing.T) {
+	b, err := loadBlocklist(writeBlocklist(t, "# comment", "", "  forbidden  ", `credit card \d+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 2 {
+		t.Fatalf("loaded %d patterns, want 2", len(b))
+	}
+	tests := []struct {
+		msg     string
+		blocked bool
+	}{
+		{"hello there", false},
+		{"this is forbidden", true},
+		{"FORBIDDEN words", true},
+		{"my credit card 1234", true},
+		{"my credit card number", false},
+		{"# comment", false},
+	}
+	for _, tt := range tests {
+		if got := b.match(tt.msg) != nil; got != tt.blocked {
+			t.Errorf("match(%q) = %v, want %v", tt.msg, got, tt.blocked)
+		}
+	}
+}
+
+func TestLoadBlocklistInvalid(t *testing.T) {
+	_, err := loadBlocklist(writeBlocklist(t, "ok", "bad("))
+	if err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("err = %v, want an error naming line 2", err)
+	}
+}
+
+func TestChatBlocklist(t *testing.T) {
+	llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("ok")}}
+	c := newTestChat(t, llm, "-blocklist-file", writeBlocklist(t, "forbidden"))
+
+	rec := c.get(t, nil, "msg", "something Forbidden", "node_id", "n")
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), `"code":"blocked"`) {
+		t.Errorf("blocked prompt: status = %d: %s", rec.Code, rec.Body)
+	}
+	if n := llm.numCalls(); n != 0 {
+		t.Errorf("model called %d times for a blocked prompt", n)
+	}
+
+	rec = c.get(t, nil, "msg", "something else", "node_id", "n")
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("allowed prompt: status = %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// errCircuitOpen is returned without calling the model while the circuit
+// breaker is open.
+var errCircuitOpen = errors.New("model circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breakerModel fails fast after threshold consecutive upstream failures,
+// as told by isUpstreamFailure.
+// Once cooldown has passed, it lets a single request through to probe the
+// model. A success closes the circuit and a failure opens it again.
+type breakerModel struct {
+	model.LLM
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreakerModel(llm model.LLM, threshold int, cooldown time.Duration) *breakerModel {
+	return &breakerModel{
+		LLM:       llm,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+func (m *breakerModel) GetGoogleLLMVariant() genai.Backend {
+	return googleLLMVariant(m.LLM)
+}
+
+// allow reports whether a request may be sent to the model.
+func (m *breakerModel) allow() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch m.state {
+	case breakerOpen:
+		if time.Since(m.openedAt) < m.cooldown {
+			return false
+		}
+		m.state = breakerHalfOpen
+		slog.Info("circuit breaker half-open, probing model")
+		fallthrough
+	case breakerHalfOpen:
+		if m.probing {
+			return false
+		}
+		m.probing = true
+	}
+	return true
+}
+
+// record updates the breaker with the outcome of a request.
+func (m *breakerModel) record(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.probing = false
+	if err == nil {
+		if m.state != breakerClosed {
+			slog.Info("circuit breaker closed")
+		}
+		m.state = breakerClosed
+		m.failures = 0
+		return
+	}
+
+	m.failures++
+	if m.state == breakerHalfOpen || m.failures >= m.threshold {
+		if m.state != breakerOpen {
+			slog.Warn("circuit breaker open", "failures", m.failures, "cooldown", m.cooldown)
+		}
+		m.state = breakerOpen
+		m.openedAt = time.Now()
+	}
+}
+
+func (m *breakerModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		if !m.allow() {
+			yield(nil, errCircuitOpen)
+			return
+		}
+
+		var failed error
+		defer func() {
+			// A client going away says nothing about the model's health.
+			if !errors.Is(failed, context.Canceled) {
+				m.record(failed)
+			} else {
+				m.mu.Lock()
+				m.probing = false
+				m.mu.Unlock()
+			}
+		}()
+		for resp, err := range m.LLM.GenerateContent(ctx, req, stream) {
+			if err != nil && (isUpstreamFailure(err) || errors.Is(err, context.Canceled)) {
+				failed = err
+			}
+			if !yield(resp, err) {
+				return
+			}
+		}
+	}
+}
+
+// isUpstreamFailure reports whether err says the model is unhealthy: a
+// 5xx or 429 from the API, or a failure to reach it. Other 4xx errors are
+// about the request, and the model answered them.
+func isUpstreamFailure(err error) bool {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code >= http.StatusInternalServerError || apiErr.Code == http.StatusTooManyRequests
+	}
+	var urlErr *url.Error
+	var netErr net.Error
+	return errors.As(err, &urlErr) || errors.As(err, &netErr)
+}
+
+// metrics reports the breaker state for /metrics.
+func (m *breakerModel) metrics() any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return map[string]any{
+		"state":    m.state.String(),
+		"failures": m.failures,
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+func TestIsUpstreamFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"500", genai.APIError{Code: 500}, true},
+		{"503 wrapped", fmt.Errorf("failed to call model: %w", genai.APIError{Code: 503}), true},
+		{"429", genai.APIError{Code: 429}, true},
+		{"400", genai.APIError{Code: 400}, false},
+		{"403", genai.APIError{Code: 403}, false},
+		{"404", genai.APIError{Code: 404}, false},
+		{"transport", &url.Error{Op: "Post", URL: "https://example.com", Err: errors.New("connection refused")}, true},
+		{"other", errors.New("empty response"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUpstreamFailure(tt.err); got != tt.want {
+				t.Errorf("isUpstreamFailure(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakerModel(t *testing.T) {
+	unavailable := fakeReply{err: genai.APIError{Code: 503}}
+	badRequest := fakeReply{err: genai.APIError{Code: 400}}
+	tests := []struct {
+		name      string
+		replies   []fakeReply
+		calls     int
+		wantState breakerState
+		wantCalls int
+	}{
+		{"successes stay closed", []fakeReply{textReply("ok")}, 5, breakerClosed, 5},
+		{"client errors stay closed", []fakeReply{badRequest}, 5, breakerClosed, 5},
+		{"below threshold stays closed", []fakeReply{unavailable, unavailable, textReply("ok"), unavailable, unavailable}, 5, breakerClosed, 5},
+		{"threshold opens", []fakeReply{unavailable}, 3, breakerOpen, 3},
+		{"open fails fast", []fakeReply{unavailable}, 6, breakerOpen, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeModel{name: "fake", replies: tt.replies}
+			b := newBreakerModel(fake, 3, time.Hour)
+			for range tt.calls {
+				drain(b, &model.LLMRequest{})
+			}
+			if b.state != tt.wantState {
+				t.Errorf("state = %v, want %v", b.state, tt.wantState)
+			}
+			if got := fake.numCalls(); got != tt.wantCalls {
+				t.Errorf("model called %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestBreakerModelProbe(t *testing.T) {
+	fake := &fakeModel{name: "fake", replies: []fakeReply{{err: genai.APIError{Code: 500}}, textReply("ok")}}
+	b := newBreakerModel(fake, 1, time.Millisecond)
+	if err := drain(b, &model.LLMRequest{}); err == nil {
+		t.Fatal("first call succeeded")
+	}
+	if err := drain(b, &model.LLMRequest{}); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("call while open: got %v, want errCircuitOpen", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if err := drain(b, &model.LLMRequest{}); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if b.state != breakerClosed {
+		t.Errorf("state after successful probe = %v, want closed", b.state)
+	}
+}
+
+func TestBreakerModelIgnoresCanceled(t *testing.T) {
+	fake := &fakeModel{name: "fake", replies: []fakeReply{{err: context.Canceled}}}
+	b := newBreakerModel(fake, 1, time.Hour)
+	drain(b, &model.LLMRequest{})
+	if b.state != breakerClosed || b.failures != 0 {
+		t.Errorf("state = %v with %d failures, want closed with 0", b.state, b.failures)
+	}
+}
+
+func TestBreakerMetrics(t *testing.T) {
+	fake := &fakeModel{name: "fake", replies: []fakeReply{{err: genai.APIError{Code: 503}}}}
+	b := newBreakerModel(fake, 2, time.Hour)
+	h := metricsHandler(map[string]func() any{"circuit_breaker": b.metrics})
+
+	for i, want := range []string{`{"failures":0,"state":"closed"}`, `{"failures":1,"state":"closed"}`, `{"failures":2,"state":"open"}`} {
+		if i > 0 {
+			drain(b, &model.LLMRequest{})
+		}
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if got := strings.TrimSpace(rec.Body.String()); got != `{"circuit_breaker":`+want+`}` {
+			t.Errorf("after %d failures: metrics = %s, want circuit_breaker %s", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/adk/session"
+)
+
+// errBudgetExceeded is returned when a session has used up its turn or
+// token allowance.
+var errBudgetExceeded = errors.New("session budget exceeded")
+
+// checkBudget returns an error wrapping errBudgetExceeded if the session has
+// reached maxTurns user turns or spent tokenBudget tokens. A limit of zero
+// is not enforced. A session that does not exist yet is within budget.
+func checkBudget(ctx context.Context, sessionService session.Service, sessionID string, maxTurns int, tokenBudget int64) error {
+	if maxTurns <= 0 && tokenBudget <= 0 {
+		return nil
+	}
+	resp, err := sessionService.Get(ctx, &session.GetRequest{AppName: appName, UserID: sessionID, SessionID: sessionID})
+	if err != nil {
+		return nil
+	}
+
+	var turns int
+	var tokens int64
+	for event := range resp.Session.Events().All() {
+		if event.Author == "user" {
+			turns++
+		}
+		if event.UsageMetadata != nil {
+			tokens += int64(event.UsageMetadata.TotalTokenCount)
+		}
+	}
+	if maxTurns > 0 && turns >= maxTurns {
+		return fmt.Errorf("%w: %d of %d turns used", errBudgetExceeded, turns, maxTurns)
+	}
+	if tokenBudget > 0 && tokens >= tokenBudget {
+		return fmt.Errorf("%w: %d of %d tokens used", errBudgetExceeded, tokens, tokenBudget)
+	}
+	return nil
+}
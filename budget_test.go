@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+func TestChatBudget(t *testing.T) {
+	usage := fakeReply{resps: []*model.LLMResponse{{
+		Content:       genai.NewContentFromText("ok", genai.RoleModel),
+		FinishReason:  genai.FinishReasonStop,
+		UsageMetadata: &genai.GenerateContentResponseUsageMetadata{TotalTokenCount: 60},
+	}}}
+	tests := []struct {
+		name string
+		args []string
+		want []int // status of each message in one session
+	}{
+		{"no limits", nil, []int{200, 200, 200, 200}},
+		{"turns", []string{"-session-max-turns", "2"}, []int{200, 200, 429, 429}},
+		{"tokens", []string{"-session-token-budget", "100"}, []int{200, 200, 429}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := &fakeModel{name: "fake", replies: []fakeReply{usage}}
+			c := newTestChat(t, llm, tt.args...)
+			for i, want := range tt.want {
+				rec := c.get(t, nil, "msg", "hi", "node_id", "n")
+				if rec.Code != want {
+					t.Fatalf("message %d: status = %d, want %d: %s", i+1, rec.Code, want, rec.Body)
+				}
+				if want == http.StatusTooManyRequests {
+					if code := decodeError(t, rec); code != "budget_exceeded" {
+						t.Errorf("code = %q, want budget_exceeded", code)
+					}
+				}
+			}
+			if rec := c.get(t, nil, "msg", "hi", "node_id", "other"); rec.Code != http.StatusOK {
+				t.Errorf("another session: status = %d, want 200", rec.Code)
+			}
+			allowed := 0
+			for _, s := range tt.want {
+				if s == http.StatusOK {
+					allowed++
+				}
+			}
+			if n := llm.numCalls(); n != allowed+1 {
+				t.Errorf("model called %d times, want %d", n, allowed+1)
+			}
+		})
+	}
+}
+
+func TestCheckBudgetMissingSession(t *testing.T) {
+	c := newTestChat(t, echoModel{})
+	err := checkBudget(t.Context(), c.sessionService, "nobody", 1, 1)
+	if errors.Is(err, errBudgetExceeded) {
+		t.Errorf("checkBudget for a new session = %v, want nil", err)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// chatHandler answers GET / with the model's reply to the msg query
+// parameter, in the session chosen by requestSessionID. The caller holds
+// the session's lock.
+func chatHandler(cfg *config, run *runner.Runner, sessionService session.Service, blocked blocklist, files *fileStore, spill *spillStore, reqLog *requestLog, backoff *sessionBackoff) http.Handler {
+	fail := errorWriter(cfg.ErrorFormat)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := r.URL.Query().Get("msg")
+		if msg == "" {
+			if cfg.EmptyMsgBehavior == "greeting" {
+				writeReply(w, r, cfg.Greeting, "")
+				return
+			}
+			fail(w, http.StatusBadRequest, "missing_msg", "msg query parameter is required")
+			return
+		}
+		if e := checkPrompt(msg, cfg.MaxInput, blocked); e != nil {
+			fail(w, e.Status, e.Code, e.Message)
+			return
+		}
+
+		// format=code answers with only the reply's first fenced code
+		// block, and format=code-all with all of them.
+		codeFormat := r.URL.Query().Get("format")
+		switch codeFormat {
+		case "", "code", "code-all":
+		default:
+			fail(w, http.StatusBadRequest, "invalid_format", "format must be code or code-all")
+			return
+		}
+
+		// alternatives=true answers with every candidate reply as JSON.
+		var wantAlternatives bool
+		if s := r.URL.Query().Get("alternatives"); s != "" {
+			var err error
+			if wantAlternatives, err = strconv.ParseBool(s); err != nil {
+				fail(w, http.StatusBadRequest, "invalid_parameter", "alternatives must be true or false")
+				return
+			}
+			if wantAlternatives && codeFormat != "" {
+				fail(w, http.StatusBadRequest, "invalid_parameter", "alternatives cannot be used with format")
+				return
+			}
+		}
+
+		overrides, e := parseGenerationOverrides(r.URL.Query())
+		if e != nil {
+			fail(w, e.Status, e.Code, e.Message)
+			return
+		}
+		if wantAlternatives && (overrides == nil || overrides.Candidates == 0) {
+			if overrides == nil {
+				overrides = &generationOverrides{}
+			}
+			overrides.Candidates = defaultAlternatives
+		}
+
+		metadata := make(map[string]any)
+		for _, key := range metadataKeys {
+			value := strings.TrimSpace(r.URL.Query().Get(key))
+			if value != "" {
+				switch key {
+				case "hops", "node_count", "direct_count":
+					if i, err := strconv.Atoi(value); err == nil {
+						metadata[key] = i
+					} else {
+						metadata[key] = value
+					}
+				case "snr", "rssi":
+					if f, err := strconv.ParseFloat(value, 64); err == nil {
+						metadata[key] = f
+					} else {
+						metadata[key] = value
+					}
+				default:
+					metadata[key] = value
+				}
+			}
+		}
+		if dropped := capMetadata(metadata, cfg.MaxMetadataBytes); len(dropped) > 0 {
+			slog.Warn("metadata over -max-metadata-bytes, dropped keys", "dropped", dropped, "limit", cfg.MaxMetadataBytes)
+		}
+
+		sessionID, err := requestSessionID(r)
+		if err != nil {
+			fail(w, http.StatusBadRequest, "invalid_session", err.Error())
+			return
+		}
+		// slog.Info("creating new chat", "session_id", sessionID)
+		if backoff != nil {
+			if d := backoff.wait(sessionID); d > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(d/time.Second)+1))
+				fail(w, http.StatusTooManyRequests, "session_backoff", "requests for this session have failed repeatedly; try again in "+d.Round(time.Second).String())
+				return
+			}
+		}
+
+		loc, err := requestTimezone(r)
+		if err != nil {
+			fail(w, http.StatusBadRequest, "invalid_timezone", err.Error())
+			return
+		}
+
+		ctx := r.Context()
+		if overrides != nil {
+			ctx = withGenerationOverrides(ctx, overrides)
+		}
+		if loc != nil {
+			ctx = withTimezone(ctx, loc)
+		}
+
+		if err := checkBudget(ctx, sessionService, sessionID, cfg.MaxTurns, cfg.TokenBudget); err != nil {
+			slog.Warn("session over budget", "session_id", sessionID, "error", err)
+			fail(w, http.StatusTooManyRequests, "budget_exceeded", err.Error())
+			return
+		}
+
+		var fileParts []*genai.Part
+		if names := r.URL.Query()["file"]; len(names) > 0 {
+			if files == nil {
+				fail(w, http.StatusBadRequest, "files_unavailable", "file attachments require the Gemini API")
+				return
+			}
+			var e *apiError
+			if fileParts, e = files.parts(ctx, names); e != nil {
+				fail(w, e.Status, e.Code, e.Message)
+				return
+			}
+		}
+
+		_, err = sessionService.Get(ctx, &session.GetRequest{AppName: appName, UserID: sessionID, SessionID: sessionID})
+		newSession := err != nil
+
+		switch cfg.MetadataMode {
+		case "none":
+			clear(metadata)
+		case "first-turn-only":
+			// Session state adds nothing to the message, and in a shared
+			// channel it must follow whoever sent the latest message.
+			if !newSession && cfg.MetadataTarget != "system" {
+				clear(metadata)
+			}
+		}
+
+		userContent, stateDelta := userTurn(msg, metadata, cfg.MetadataTarget, fileParts...)
+
+		if newSession && len(cfg.LangSystem) > 0 {
+			if lang := detectLanguage(msg); lang != "" {
+				slog.Info("detected language", "session_id", sessionID, "language", lang)
+				stateDelta[languageKey] = lang
+			}
+		}
+
+		var opts []runner.RunOption
+		if len(stateDelta) > 0 {
+			opts = append(opts, runner.WithStateDelta(stateDelta))
+		}
+
+		// Clients that accept server-sent events get the reply streamed as
+		// the model writes it.
+		var runConfig agent.RunConfig
+		var partial func(string) error
+		var sse *sseWriter
+		if codeFormat == "" && !wantAlternatives && negotiateFormat(r.Header.Get("Accept")) == formatEventStream {
+			runConfig.StreamingMode = agent.StreamingModeSSE
+			sse = &sseWriter{w: w, fallback: fail, timeout: cfg.WriteTimeout}
+			partial = sse.partial
+		}
+
+		upstreamStart := time.Now()
+		rep, err := streamReply(run.Run(ctx, sessionID, sessionID, userContent, runConfig, opts...), partial)
+		var respText string
+		if err == nil {
+			respText = cfg.Filter.apply(rep)
+		}
+		if reqLog != nil {
+			e := requestLogEntry{Time: upstreamStart, Session: sessionID, Prompt: msg, Metadata: metadata, Model: rep.ModelVersion}
+			if err != nil {
+				e.Error = err.Error()
+			} else {
+				e.Response = respText
+			}
+			reqLog.log(e)
+		}
+		fail := fail
+		if sse != nil {
+			fail = sse.fail
+		}
+		if ctx.Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+			// The client went away, so there is no one to answer.
+			slog.Debug("request canceled", "session_id", sessionID, "error", err)
+			return
+		}
+		if errors.Is(err, errCircuitOpen) {
+			fail(w, http.StatusServiceUnavailable, "unavailable", "the AI service is temporarily unavailable")
+			return
+		}
+		var blockedErr *promptBlockedError
+		if errors.As(err, &blockedErr) {
+			slog.Warn("prompt blocked by model", "session_id", sessionID, "reason", blockedErr.Reason)
+			e := blockedErr.apiError()
+			fail(w, e.Status, e.Code, e.Message)
+			return
+		}
+		if err != nil && backoff != nil {
+			backoff.failure(sessionID)
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			slog.Warn("timed out waiting for AI", "session_id", sessionID, "error", err)
+			fail(w, http.StatusGatewayTimeout, "upstream_timeout", "timed out waiting for the AI service")
+			return
+		}
+		if err != nil {
+			slog.Error("failed to get response from AI", "error", err)
+			fail(w, http.StatusInternalServerError, "upstream_error", "failed to get response from AI")
+			return
+		}
+		if backoff != nil {
+			backoff.success(sessionID)
+		}
+		upstream := time.Since(upstreamStart)
+		recordUsage(ctx, rep)
+		if len([]byte(respText)) > 200 {
+			slog.Warn("response too long", "length", len([]byte(respText)), "response", respText)
+		}
+
+		if sse != nil {
+			if err := sse.done(cfg.Prefix+respText, rep.ModelVersion); err != nil {
+				slog.Debug("failed to write reply", "error", err)
+			}
+			return
+		}
+		w.Header().Set("Server-Timing", fmt.Sprintf("upstream;dur=%.1f", float64(upstream.Microseconds())/1000))
+		if rep.ModelVersion != "" {
+			w.Header().Set("X-Model", rep.ModelVersion)
+		}
+		if wantAlternatives {
+			// Without several candidates, as from an OpenAI compatible
+			// provider, the reply is the only alternative.
+			texts := []string{respText}
+			if rep.Alternatives != nil {
+				texts = texts[:0]
+				for _, t := range rep.Alternatives {
+					texts = append(texts, cfg.Filter.apply(reply{Text: t, ModelVersion: rep.ModelVersion}))
+				}
+			}
+			if err := writeAlternatives(w, texts, rep.FilteredAlternatives, rep.ModelVersion); err != nil {
+				slog.Error("failed to write reply", "error", err)
+			}
+			return
+		}
+		var codeLang string
+		if codeFormat != "" {
+			code, lang, ok := extractCode(respText, codeFormat == "code-all")
+			if !ok {
+				fail(w, http.StatusUnprocessableEntity, "no_code", "the reply has no code block")
+				return
+			}
+			respText, codeLang = code, lang
+		}
+		if spill != nil && len(respText) > cfg.SpillThreshold {
+			// Send a link to large replies rather than the reply.
+			id, err := spill.save(respText)
+			if err != nil {
+				slog.Error("failed to save large reply", "error", err)
+				fail(w, http.StatusInternalServerError, "internal", "failed to save reply")
+				return
+			}
+			respText = "/media/" + id
+			w.Header().Set("Location", respText)
+			slog.Info("reply saved for download", "session_id", sessionID, "path", respText, "length", len(rep.Text))
+		} else if codeFormat != "" {
+			w.Header().Set("Content-Type", codeContentType(codeLang))
+			if codeLang != "" {
+				w.Header().Set("X-Code-Language", codeLang)
+			}
+			if _, err := w.Write([]byte(respText)); err != nil {
+				slog.Error("failed to write reply", "error", err)
+			}
+			return
+		}
+		if err := writeReply(w, r, cfg.Prefix+respText, rep.ModelVersion); err != nil {
+			slog.Error("failed to write reply", "error", err)
+		}
+	})
+}
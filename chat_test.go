@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+)
+
+// newTestRunner returns a runner for llm with the built-in system
+// instructions and the callbacks every request gets.
+func newTestRunner(t *testing.T, cfg *config, sessionService session.Service, llm model.LLM, beforeModel ...llmagent.BeforeModelCallback) *runner.Runner {
+	t.Helper()
+	var instructions atomic.Pointer[systemInstructions]
+	si, err := loadSystemInstructions(filepath.Join(t.TempDir(), "missing.txt"), cfg.LangSystem, cfg.SystemVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instructions.Store(si)
+	beforeModel = append([]llmagent.BeforeModelCallback{sessionModelCallback(), generationCallback()}, beforeModel...)
+	run, err := buildRunner(context.Background(), cfg, sessionService, llm, &instructions, "", beforeModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return run
+}
+
+// testChat is a chat handler answered by llm, configured by args.
+type testChat struct {
+	http.Handler
+	cfg            *config
+	sessionService session.Service
+}
+
+func newTestChat(t *testing.T, llm model.LLM, args ...string) *testChat {
+	t.Helper()
+	cfg, _ := testConfig(t, append([]string{"-dry-run"}, args...)...)
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	var blocked blocklist
+	if cfg.BlockFile != "" {
+		var err error
+		if blocked, err = loadBlocklist(cfg.BlockFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sessionService := session.InMemoryService()
+	run := newTestRunner(t, cfg, sessionService, llm)
+	return &testChat{
+		Handler:        newSessionLocks().middleware(chatHandler(cfg, run, sessionService, blocked, nil, nil, nil, nil)),
+		cfg:            cfg,
+		sessionService: sessionService,
+	}
+}
+
+// get sends GET / with the query parameters given as name, value pairs.
+func (c *testChat) get(t *testing.T, header http.Header, params ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	q := url.Values{}
+	for i := 0; i+1 < len(params); i += 2 {
+		q.Add(params[i], params[i+1])
+	}
+	req := httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestChatInputLimit(t *testing.T) {
+	c := newTestChat(t, echoModel{}, "-max-input-bytes", "8")
+	tests := []struct {
+		msg  string
+		want int
+	}{
+		{"1234567", http.StatusOK},
+		{"12345678", http.StatusOK},
+		{"123456789", http.StatusRequestEntityTooLarge},
+		{"éééé", http.StatusOK},                     // 8 bytes
+		{"ééééa", http.StatusRequestEntityTooLarge}, // 9 bytes, 5 runes
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			rec := c.get(t, nil, "msg", tt.msg, "node_id", "n")
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusRequestEntityTooLarge && !strings.Contains(rec.Body.String(), `"code":"input_too_large"`) {
+				t.Errorf("body = %s, want input_too_large", rec.Body)
+			}
+		})
+	}
+}
+
+func TestCheckPrompt(t *testing.T) {
+	tests := []struct {
+		msg      string
+		maxInput int
+		want     int // 0 for no error
+	}{
+		{"", 4, 0},
+		{"abcd", 4, 0},
+		{"abcde", 4, http.StatusRequestEntityTooLarge},
+		{"abcde", 0, 0},
+		{"ab", 1, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		e := checkPrompt(tt.msg, tt.maxInput, nil)
+		switch {
+		case tt.want == 0 && e != nil:
+			t.Errorf("checkPrompt(%q, %d) = %v, want nil", tt.msg, tt.maxInput, e)
+		case tt.want != 0 && (e == nil || e.Status != tt.want):
+			t.Errorf("checkPrompt(%q, %d) = %v, want status %d", tt.msg, tt.maxInput, e, tt.want)
+		}
+	}
+}
+
+func TestBatchInputLimit(t *testing.T) {
+	cfg, _ := testConfig(t, "-dry-run", "-batch-concurrency", "2", "-max-input-bytes", "4")
+	sessionService := session.InMemoryService()
+	h := batchHandler(cfg, newTestRunner(t, cfg, sessionService, echoModel{}), sessionService, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(`["abcd","abcde"]`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `"text":"[dry-run] abcd`) || !strings.Contains(body, `"code":"input_too_large"`) {
+		t.Errorf("body = %s, want the first prompt answered and the second too large", body)
+	}
+}
+
+// TestChatCanceled cancels a request while the model is answering it. The
+// model call ends with the request, and nothing is written.
+func TestChatCanceled(t *testing.T) {
+	llm := blockingModel{started: make(chan struct{}, 1)}
+	c := newTestChat(t, llm)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/?msg=hi&node_id=n", nil)
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		c.ServeHTTP(rec, req)
+		close(done)
+	}()
+
+	<-llm.started
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not return after the request was canceled")
+	}
+	if rec.Body.Len() != 0 || rec.Header().Get("Content-Type") != "" {
+		t.Errorf("wrote a response to a canceled request: %d %q", rec.Code, rec.Body)
+	}
+}
+
+// sessionGetRequest asks for a chat session by its ID.
+func sessionGetRequest(id string) *session.GetRequest {
+	return &session.GetRequest{AppName: appName, UserID: id, SessionID: id}
+}
+
+func TestChatServerTiming(t *testing.T) {
+	c := newTestChat(t, echoModel{})
+	rec := c.get(t, nil, "msg", "hi")
+	h := rec.Header().Get("Server-Timing")
+	dur, ok := strings.CutPrefix(h, "upstream;dur=")
+	if !ok {
+		t.Fatalf("Server-Timing = %q, want upstream;dur=<ms>", h)
+	}
+	if ms, err := strconv.ParseFloat(dur, 64); err != nil || ms < 0 {
+		t.Errorf("Server-Timing duration %q is not a number of milliseconds", dur)
+	}
+}
+
+// TestChatContextErrors tells a client that went away from a model call
+// that failed with a context error of its own.
+func TestChatContextErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		cancel      bool // cancel the request while the model answers
+		status      int  // zero when nothing is written
+		code        string
+		wantBackoff bool
+	}{
+		{"client went away", nil, true, 0, "", false},
+		{"model deadline", context.DeadlineExceeded, false, http.StatusGatewayTimeout, "upstream_timeout", true},
+		{"model canceled", context.Canceled, false, http.StatusInternalServerError, "upstream_error", true},
+		{"other error", errors.New("boom"), false, http.StatusInternalServerError, "upstream_error", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var llm model.LLM = &fakeModel{name: "fake", replies: []fakeReply{{err: tt.err}}}
+			if tt.cancel {
+				llm = blockingModel{started: make(chan struct{}, 1)}
+			}
+			cfg, _ := testConfig(t, "-dry-run")
+			sessionService := session.InMemoryService()
+			backoff := newSessionBackoff(1, time.Minute, time.Minute)
+			chat := chatHandler(cfg, newTestRunner(t, cfg, sessionService, llm), sessionService, nil, nil, nil, nil, backoff)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			rec := httptest.NewRecorder()
+			done := make(chan struct{})
+			go func() {
+				chat.ServeHTTP(rec, httptest.NewRequestWithContext(ctx, http.MethodGet, "/?msg=hi&node_id=n1", nil))
+				close(done)
+			}()
+			if tt.cancel {
+				<-llm.(blockingModel).started
+				cancel()
+			}
+			<-done
+
+			if tt.status == 0 {
+				if rec.Body.Len() != 0 {
+					t.Errorf("wrote %d %q to a client that went away", rec.Code, rec.Body)
+				}
+			} else if rec.Code != tt.status || decodeError(t, rec) != tt.code {
+				t.Errorf("status = %d, want %d %s", rec.Code, tt.status, tt.code)
+			}
+			if got := backoff.wait("n1") > 0; got != tt.wantBackoff {
+				t.Errorf("counted as a session failure: %v, want %v", got, tt.wantBackoff)
+			}
+		})
+	}
+}
+
+func TestChatEmptyMsg(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		accept string
+		status int
+		body   string
+	}{
+		{"error", nil, "", http.StatusBadRequest, ""},
+		{"greeting", []string{"-empty-msg-behavior", "greeting"}, "", http.StatusOK, "Hello! Ask me something with ?msg="},
+		{"custom greeting", []string{"-empty-msg-behavior", "greeting", "-greeting", "Hi there"}, "", http.StatusOK, "Hi there"},
+		{"greeting as JSON", []string{"-empty-msg-behavior", "greeting", "-greeting", "Hi there"}, "application/json", http.StatusOK, `{"text":"Hi there"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("unused")}}
+			c := newTestChat(t, llm, tt.args...)
+			rec := c.get(t, http.Header{"Accept": {tt.accept}}, "node_id", "n1")
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.body == "" {
+				if code := decodeError(t, rec); code != "missing_msg" {
+					t.Errorf("code = %q, want missing_msg", code)
+				}
+			} else if rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body, tt.body)
+			}
+			if n := llm.numCalls(); n != 0 {
+				t.Errorf("model called %d times", n)
+			}
+			if _, err := c.sessionService.Get(t.Context(), sessionGetRequest("n1")); err == nil {
+				t.Error("a session was created")
+			}
+		})
+	}
+	if cfg, _ := testConfig(t, "-dry-run", "-empty-msg-behavior", "shrug"); cfg.validate() == nil {
+		t.Error("validate accepted -empty-msg-behavior shrug")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// codeTypes maps the language hints of fenced code blocks to media types.
+// Languages a browser would run or render, such as HTML and SVG, are served
+// as plain text.
+var codeTypes = map[string]string{
+	"bash":       "text/x-shellscript; charset=utf-8",
+	"c":          "text/x-c; charset=utf-8",
+	"cpp":        "text/x-c++; charset=utf-8",
+	"css":        "text/css; charset=utf-8",
+	"csv":        "text/csv; charset=utf-8",
+	"go":         "text/x-go; charset=utf-8",
+	"java":       "text/x-java; charset=utf-8",
+	"javascript": "text/javascript; charset=utf-8",
+	"js":         "text/javascript; charset=utf-8",
+	"json":       "application/json",
+	"markdown":   "text/markdown; charset=utf-8",
+	"md":         "text/markdown; charset=utf-8",
+	"py":         "text/x-python; charset=utf-8",
+	"python":     "text/x-python; charset=utf-8",
+	"rust":       "text/x-rust; charset=utf-8",
+	"sh":         "text/x-shellscript; charset=utf-8",
+	"sql":        "application/sql",
+	"toml":       "application/toml",
+	"ts":         "text/x-typescript; charset=utf-8",
+	"typescript": "text/x-typescript; charset=utf-8",
+	"xml":        "application/xml",
+	"yaml":       "application/yaml",
+	"yml":        "application/yaml",
+}
+
+// codeContentType returns the Content-Type for code in the given language.
+func codeContentType(lang string) string {
+	if t, ok := codeTypes[strings.ToLower(lang)]; ok {
+		return t
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// extractCode returns the contents of the first fenced code block in a
+// markdown reply, or of all of them separated by blank lines, along with
+// their language hint. The language is empty when there is none or the
+// blocks disagree. ok is false when the reply has no fenced code block.
+func extractCode(reply string, all bool) (code, lang string, ok bool) {
+	source := []byte(reply)
+	doc := markdown.Parser().Parse(text.NewReader(source))
+
+	var blocks []string
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		fenced, isFenced := n.(*ast.FencedCodeBlock)
+		if !entering || !isFenced {
+			return ast.WalkContinue, nil
+		}
+		var b strings.Builder
+		lines := fenced.Lines()
+		for i := range lines.Len() {
+			seg := lines.At(i)
+			b.Write(seg.Value(source))
+		}
+		l := string(fenced.Language(source))
+		if len(blocks) == 0 {
+			lang = l
+		} else if l != lang {
+			lang = ""
+		}
+		blocks = append(blocks, b.String())
+		if !all {
+			return ast.WalkStop, nil
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	if len(blocks) == 0 {
+		return "", "", false
+	}
+	return strings.Join(blocks, "\n"), lang, true
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestExtractCode(t *testing.T) {
+	const one = "Here:\n\n```go\nx := 1\n```\n"
+	const two = "First:\n\n```go\nx := 1\n```\n\nThen:\n\n```go\ny := 2\n```\n"
+	const mixed = "```sh\nls\n```\n\n```python\nprint(1)\n```\n"
+	tests := []struct {
+		name     string
+		reply    string
+		all      bool
+		wantCode string
+		wantLang string
+		wantOK   bool
+	}{
+		{"one", one, false, "x := 1\n", "go", true},
+		{"one, all", one, true, "x := 1\n", "go", true},
+		{"first of two", two, false, "x := 1\n", "go", true},
+		{"all of two", two, true, "x := 1\n\ny := 2\n", "go", true},
+		{"languages disagree", mixed, true, "ls\n\nprint(1)\n", "", true},
+		{"first keeps its language", mixed, false, "ls\n", "sh", true},
+		{"no language", "```\nplain\n```", false, "plain\n", "", true},
+		{"indented code is not fenced", "    x := 1\n", false, "", "", false},
+		{"inline code", "use `x := 1`", false, "", "", false},
+		{"none", "no code here", false, "", "", false},
+	}
+	for _, tt := range tests {
+		code, lang, ok := extractCode(tt.reply, tt.all)
+		if code != tt.wantCode || lang != tt.wantLang || ok != tt.wantOK {
+			t.Errorf("%s: extractCode() = %q, %q, %v; want %q, %q, %v", tt.name, code, lang, ok, tt.wantCode, tt.wantLang, tt.wantOK)
+		}
+	}
+}
+
+func TestChatCodeFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		reply       string
+		format      string
+		status      int
+		contentType string
+		lang        string
+		body        string
+	}{
+		{"go", "```go\nx := 1\n```", "code", http.StatusOK, "text/x-go; charset=utf-8", "go", "x := 1\n"},
+		{"html is plain text", "```html\n<b>hi</b>\n```", "code", http.StatusOK, "text/plain; charset=utf-8", "html", "<b>hi</b>\n"},
+		{"json", "```JSON\n{}\n```", "code", http.StatusOK, "application/json", "JSON", "{}\n"},
+		{"all", "```go\na\n```\n\n```go\nb\n```", "code-all", http.StatusOK, "text/x-go; charset=utf-8", "go", "a\n\nb\n"},
+		{"none", "no code", "code", http.StatusUnprocessableEntity, "application/json", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestChat(t, &fakeModel{name: "fake", replies: []fakeReply{textReply(tt.reply)}})
+			rec := c.get(t, nil, "msg", "hi", "format", tt.format)
+			if rec.Code != tt.status || rec.Header().Get("Content-Type") != tt.contentType || rec.Header().Get("X-Code-Language") != tt.lang {
+				t.Errorf("got %d %q language %q, want %d %q language %q", rec.Code, rec.Header().Get("Content-Type"), rec.Header().Get("X-Code-Language"), tt.status, tt.contentType, tt.lang)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// config holds the settings from the command line and environment.
+type config struct {
+	// Server
+	Addr                 string
+	ReadTimeout          time.Duration
+	WriteTimeout         time.Duration
+	IdleTimeout          time.Duration
+	UI                   bool
+	Favicon              string
+	CookieSecret         string
+	AdminToken           string
+	ErrorFormat          string
+	EmptyMsgBehavior     string
+	Greeting             string
+	ResponseCacheControl string
+	IdempotencyTTL       time.Duration
+	QueueWorkers         int
+	QueueSize            int
+	QueueTimeout         time.Duration
+	BatchConcurrency     int
+	MaxUploadBytes       int64
+	SpillThreshold       int
+	SpillTTL             time.Duration
+
+	// Model
+	Provider       string
+	Model          string
+	Fallbacks      string
+	FallbackModels []string
+	EmbedModel     string
+	APIKeys        string
+	Tokens         []string
+	KeyCooldown    time.Duration
+	BaseURL        string
+	OpenAIBaseURL  string
+	HTTPProxy      string
+	DryRun         bool
+	Warmup         bool
+	RetryEmpty     int
+	CBThreshold    int
+	CBCooldown     time.Duration
+
+	// Prompts and replies
+	System           string
+	SearchSystem     string
+	SystemDelimiter  string
+	LangSystem       map[string]string
+	SystemVars       map[string]string
+	InjectTime       bool
+	TimeFormat       string
+	Prefix           string
+	PromptPrefix     string
+	PromptSuffix     string
+	MaxInput         int
+	BlockFile        string
+	MetadataMode     string
+	MetadataTarget   string
+	MaxMetadataBytes int
+	Filter           replyFilter
+
+	// Sessions
+	MaxTurns                int
+	TokenBudget             int64
+	HistoryMaxBytes         int
+	HistoryCompaction       string
+	HistoryKeepFraction     float64
+	SessionFailureThreshold int
+	SessionBackoff          time.Duration
+	SessionBackoffMax       time.Duration
+
+	// Logging
+	OTel             bool
+	LogSampleRate    int
+	SlowThreshold    time.Duration
+	PriceInput       float64
+	PriceOutput      float64
+	RequestLog       string
+	RequestLogRedact bool
+
+	// Modes
+	Oneshot bool
+	Replay  string
+
+	// MeshMonitor
+	MeshAPIURL     string
+	MeshAPIToken   string
+	MeshSource     string
+	MeshAPITimeout time.Duration
+
+	// Flags holds the value of every flag, with secrets redacted, for
+	// /config.
+	Flags map[string]string
+}
+
+// parseConfig defines the flags on fs, parses args, and fills in the
+// settings that come from the environment. It does not check the values;
+// see validate.
+func parseConfig(fs *flag.FlagSet, args []string) (*config, error) {
+	c := &config{
+		LangSystem: make(map[string]string),
+		SystemVars: make(map[string]string),
+	}
+
+	fs.StringVar(&c.Addr, "addr", ":8080", "TCP host:port to listen on")
+	fs.StringVar(&c.System, "system", "system.txt", "Path to system instructions file; if missing, SYSTEM_INSTRUCTION or the built-in instructions are used")
+	fs.StringVar(&c.SystemDelimiter, "system-delimiter", "", "Line, such as ---, that splits the system instructions into separate parts")
+	fs.BoolVar(&c.InjectTime, "inject-time", false, "Tell the model the current time, in the client's X-Timezone if given, on every request")
+	fs.StringVar(&c.TimeFormat, "time-format", "Monday, January 2, 2006 15:04 MST", "Go time layout for -inject-time")
+	fs.StringVar(&c.SearchSystem, "search-system", "search_system.txt", "Path to search system instructions file")
+	fs.StringVar(&c.Prefix, "prefix", "", "Prefix to include in response")
+	fs.StringVar(&c.PromptPrefix, "prompt-prefix", "", "Text added on a line before each user message sent to the model")
+	fs.StringVar(&c.PromptSuffix, "prompt-suffix", "", "Text added on a line after each user message sent to the model")
+	fs.BoolVar(&c.OTel, "otel", false, "Output OpenTelemetry spans to stdout")
+	fs.StringVar(&c.Model, "model", "gemini-3.1-flash-lite", "Gemini model to use")
+	fs.StringVar(&c.Fallbacks, "fallback-models", "", "Comma-separated models to try when the primary model is overloaded")
+	fs.StringVar(&c.HTTPProxy, "http-proxy", "", "Proxy URL to use for Gemini API requests")
+	fs.StringVar(&c.BaseURL, "base-url", "", "Override the Gemini API base URL")
+	fs.StringVar(&c.Provider, "provider", "gemini", "Model provider (gemini|openai)")
+	fs.StringVar(&c.OpenAIBaseURL, "openai-base-url", "https://api.openai.com/v1", "Base URL of the OpenAI compatible API, used with -provider openai")
+	fs.StringVar(&c.BlockFile, "blocklist-file", "", "Path to a file of regular expressions, one per line, that reject matching prompts")
+	fs.StringVar(&c.ResponseCacheControl, "response-cache-control", "no-store", "Cache-Control header for replies from / and /batch (empty to omit)")
+	fs.StringVar(&c.ErrorFormat, "error-format", "json", "Format of error responses from /: json or text")
+	fs.StringVar(&c.EmptyMsgBehavior, "empty-msg-behavior", "error", "How / answers a request without msg: error (400) or greeting")
+	fs.StringVar(&c.Greeting, "greeting", "Hello! Ask me something with ?msg=", "Reply to a request without msg when -empty-msg-behavior is greeting")
+	fs.BoolVar(&c.UI, "ui", false, "Serve a web chat page at /ui/")
+	fs.StringVar(&c.CookieSecret, "cookie-secret", "", "Secrets for signing session cookies, newest first, separated by commas (or CHATTY_COOKIE_SECRET)")
+	fs.StringVar(&c.Favicon, "favicon", "", "Path to a favicon to serve at /favicon.ico")
+	fs.StringVar(&c.APIKeys, "api-keys", "", "Comma-separated Gemini API keys to rotate between (default GEMINI_API_KEY)")
+	fs.DurationVar(&c.KeyCooldown, "key-cooldown", time.Minute, "How long to avoid an API key after it is rate limited")
+	fs.StringVar(&c.MetadataMode, "metadata-mode", "first-turn-only", "When to send request metadata: every-turn, first-turn-only (the system target is still updated every turn), or none")
+	fs.StringVar(&c.MetadataTarget, "metadata-target", "system", "Where to put request metadata: system (instruction template), user (prefix to the message), or separate-part")
+	fs.IntVar(&c.MaxMetadataBytes, "max-metadata-bytes", 1024, "Maximum total size of the request metadata sent to the model; keys past it are dropped (0 for no limit)")
+	fs.IntVar(&c.BatchConcurrency, "batch-concurrency", 4, "Maximum number of /batch prompts processed at once")
+	fs.IntVar(&c.QueueWorkers, "queue-workers", 0, "Number of / requests served at once, with the rest waiting in a queue (0 to disable)")
+	fs.IntVar(&c.QueueSize, "queue-size", 100, "Maximum number of / requests waiting for -queue-workers")
+	fs.DurationVar(&c.QueueTimeout, "queue-timeout", 30*time.Second, "How long a / request may wait for -queue-workers")
+	fs.StringVar(&c.EmbedModel, "embed-model", "gemini-embedding-001", "Default model for /embed")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", 30*time.Second, "Maximum time to read a request, including the body")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", 5*time.Minute, "Maximum time to handle a request and write the response; must cover model latency. Streamed replies get this long again with each chunk")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", 2*time.Minute, "Maximum time to keep an idle connection open")
+	fs.IntVar(&c.CBThreshold, "cb-threshold", 0, "Consecutive model failures that open the circuit breaker (0 to disable)")
+	fs.DurationVar(&c.CBCooldown, "cb-cooldown", 30*time.Second, "How long the circuit breaker stays open before probing the model")
+	fs.IntVar(&c.SessionFailureThreshold, "session-failure-threshold", 0, "Consecutive upstream failures after which a session's messages are refused for a while (0 to disable)")
+	fs.DurationVar(&c.SessionBackoff, "session-backoff", 10*time.Second, "First wait for a session over -session-failure-threshold; it doubles with each further failure")
+	fs.DurationVar(&c.SessionBackoffMax, "session-backoff-max", 5*time.Minute, "Longest wait for a session over -session-failure-threshold")
+	fs.IntVar(&c.MaxTurns, "session-max-turns", 0, "Maximum user turns per session (0 for no limit)")
+	fs.Int64Var(&c.TokenBudget, "session-token-budget", 0, "Maximum total tokens per session (0 for no limit)")
+	fs.BoolVar(&c.Oneshot, "oneshot", false, "Read one prompt from stdin, print the reply, and exit")
+	fs.StringVar(&c.Replay, "replay", "", "Replay the prompts in a -request-log file, print how the replies compare, and exit")
+	fs.StringVar(&c.RequestLog, "request-log", "", "Append each prompt and reply to this JSONL file")
+	fs.BoolVar(&c.RequestLogRedact, "request-log-redact", false, "Hash sessions and node names in the -request-log")
+	fs.DurationVar(&c.IdempotencyTTL, "idempotency-ttl", 10*time.Minute, "How long to remember responses by Idempotency-Key (0 to disable)")
+	fs.Float64Var(&c.PriceInput, "price-input", 0, "Price per 1K prompt tokens, for cost estimates in the request log")
+	fs.Float64Var(&c.PriceOutput, "price-output", 0, "Price per 1K response tokens, for cost estimates in the request log")
+	fs.IntVar(&c.LogSampleRate, "log-sample-rate", 1, "Log 1 in N successful requests; errors and slow requests are always logged")
+	fs.DurationVar(&c.SlowThreshold, "slow-threshold", 5*time.Second, "Requests taking at least this long are always logged (0 to disable)")
+	fs.IntVar(&c.RetryEmpty, "retry-empty", 1, "Times to resend a request when the model returns an empty response")
+	fs.Func("system-lang", "Per-language system instructions as lang=path, e.g. es=system_es.txt (may be repeated)", func(s string) error {
+		lang, path, ok := strings.Cut(s, "=")
+		if !ok || lang == "" || path == "" {
+			return errors.New("expected lang=path")
+		}
+		c.LangSystem[strings.ToLower(lang)] = path
+		return nil
+	})
+	fs.Func("system-var", "Variable for the system instruction template as key=value (may be repeated)", func(s string) error {
+		k, v, ok := strings.Cut(s, "=")
+		if !ok || k == "" {
+			return errors.New("expected key=value")
+		}
+		c.SystemVars[k] = v
+		return nil
+	})
+	fs.Func("strip-patterns", "Regular expression to remove from replies (may be repeated)", func(s string) error {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return err
+		}
+		c.Filter.strip = append(c.Filter.strip, re)
+		return nil
+	})
+	fs.StringVar(&c.Filter.empty, "empty-reply", "", "Reply to send when the model returns no text")
+	fs.BoolVar(&c.Filter.trim, "trim-response", true, "Trim white space from the start and end of replies")
+	fs.BoolVar(&c.DryRun, "dry-run", false, "Echo prompts back instead of calling the Gemini API")
+	fs.BoolVar(&c.Warmup, "warmup", false, "Send a tiny request to the model at startup to open connections and check the key and model")
+	fs.IntVar(&c.MaxInput, "max-input-bytes", 4096, "Maximum size of msg in bytes (0 for no limit)")
+	fs.IntVar(&c.HistoryMaxBytes, "history-max-bytes", 0, "Approximate maximum size of the history sent to the model, dropping the oldest turns first (0 for no limit)")
+	fs.StringVar(&c.HistoryCompaction, "history-compaction", "truncate", "How to shorten history over -history-max-bytes (truncate|summarize)")
+	fs.Float64Var(&c.HistoryKeepFraction, "history-keep-fraction", 1, "Fraction of -history-max-bytes to keep when history is shortened (0-1]")
+	fs.Int64Var(&c.MaxUploadBytes, "max-upload-bytes", 20<<20, "Maximum size of a file uploaded to /files")
+	fs.IntVar(&c.SpillThreshold, "spill-threshold", 0, "Replies larger than this many bytes are saved and served from /media/{id} instead (0 to disable)")
+	fs.DurationVar(&c.SpillTTL, "spill-ttl", time.Hour, "How long replies saved by -spill-threshold are kept")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	c.Flags = flagValues(fs)
+
+	if c.APIKeys == "" {
+		c.APIKeys = os.Getenv("GEMINI_API_KEY")
+	}
+	for key := range strings.SplitSeq(c.APIKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			c.Tokens = append(c.Tokens, key)
+		}
+	}
+	for name := range strings.SplitSeq(c.Fallbacks, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			c.FallbackModels = append(c.FallbackModels, name)
+		}
+	}
+	if c.CookieSecret == "" {
+		c.CookieSecret = os.Getenv("CHATTY_COOKIE_SECRET")
+	}
+	c.AdminToken = os.Getenv("CHATTY_ADMIN_TOKEN")
+
+	c.MeshAPIURL = os.Getenv("MESHMONITOR_API_URL")
+	c.MeshAPIToken = os.Getenv("MESHMONITOR_API_TOKEN")
+	c.MeshSource = os.Getenv("MESHMONITOR_SOURCE")
+	c.MeshAPITimeout = 15 * time.Second
+	if timeoutStr := os.Getenv("MESHMONITOR_API_TIMEOUT"); timeoutStr != "" {
+		if d, err := time.ParseDuration(timeoutStr); err == nil {
+			c.MeshAPITimeout = d
+		} else {
+			slog.Warn("invalid MESHMONITOR_API_TIMEOUT, using default", "value", timeoutStr, "error", err)
+		}
+	}
+	return c, nil
+}
+
+// validate reports every setting that is out of range or conflicts with
+// another.
+func (c *config) validate() error {
+	var errs []error
+	oneOf := func(flag, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		errs = append(errs, fmt.Errorf("invalid -%s %q, must be one of %s", flag, value, strings.Join(allowed, ", ")))
+	}
+	absoluteURL := func(flag, value string) {
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid -%s %q, must be an absolute URL", flag, value))
+		}
+	}
+
+	oneOf("metadata-mode", c.MetadataMode, "every-turn", "first-turn-only", "none")
+	oneOf("metadata-target", c.MetadataTarget, "system", "user", "separate-part")
+	oneOf("error-format", c.ErrorFormat, "json", "text")
+	oneOf("empty-msg-behavior", c.EmptyMsgBehavior, "error", "greeting")
+	oneOf("history-compaction", c.HistoryCompaction, "truncate", "summarize")
+	if c.HistoryKeepFraction <= 0 || c.HistoryKeepFraction > 1 {
+		errs = append(errs, fmt.Errorf("invalid -history-keep-fraction %v, must be more than 0 and at most 1", c.HistoryKeepFraction))
+	}
+
+	switch c.Provider {
+	case "gemini":
+		if len(c.Tokens) == 0 && !c.DryRun {
+			errs = append(errs, errors.New("GEMINI_API_KEY or -api-keys is required"))
+		}
+	case "openai":
+		absoluteURL("openai-base-url", c.OpenAIBaseURL)
+	default:
+		oneOf("provider", c.Provider, "gemini", "openai")
+	}
+	if c.HTTPProxy != "" {
+		absoluteURL("http-proxy", c.HTTPProxy)
+	}
+	if c.BaseURL != "" {
+		absoluteURL("base-url", c.BaseURL)
+	}
+	errs = append(errs,
+		atLeast("queue-workers", c.QueueWorkers, 0),
+		atLeast("queue-size", c.QueueSize, 0),
+		atLeast("batch-concurrency", c.BatchConcurrency, 1),
+		atLeast("log-sample-rate", c.LogSampleRate, 1),
+		atLeast("max-input-bytes", c.MaxInput, 0),
+		atLeast("max-metadata-bytes", c.MaxMetadataBytes, 0),
+		atLeast("max-upload-bytes", c.MaxUploadBytes, 1),
+		atLeast("history-max-bytes", c.HistoryMaxBytes, 0),
+		atLeast("spill-threshold", c.SpillThreshold, 0),
+		atLeast("retry-empty", c.RetryEmpty, 0),
+		atLeast("cb-threshold", c.CBThreshold, 0),
+		atLeast("session-failure-threshold", c.SessionFailureThreshold, 0),
+		atLeast("session-max-turns", c.MaxTurns, 0),
+		atLeast("session-token-budget", c.TokenBudget, 0),
+		atLeast("price-input", c.PriceInput, 0),
+		atLeast("price-output", c.PriceOutput, 0),
+		atLeast("read-timeout", c.ReadTimeout, 0),
+		atLeast("write-timeout", c.WriteTimeout, 0),
+		atLeast("idle-timeout", c.IdleTimeout, 0),
+		atLeast("queue-timeout", c.QueueTimeout, 0),
+		atLeast("key-cooldown", c.KeyCooldown, 0),
+		atLeast("cb-cooldown", c.CBCooldown, 0),
+		atLeast("session-backoff", c.SessionBackoff, 0),
+		atLeast("session-backoff-max", c.SessionBackoffMax, c.SessionBackoff),
+		atLeast("idempotency-ttl", c.IdempotencyTTL, 0),
+		atLeast("spill-ttl", c.SpillTTL, 0),
+		atLeast("slow-threshold", c.SlowThreshold, 0),
+	)
+	if c.Oneshot && c.Replay != "" {
+		errs = append(errs, errors.New("-oneshot and -replay cannot be used together"))
+	}
+	return errors.Join(errs...)
+}
+
+// atLeast returns an error naming flag if value is less than min.
+func atLeast[T int | int64 | float64 | time.Duration](flag string, value, min T) error {
+	if value < min {
+		return fmt.Errorf("invalid -%s %v, must be at least %v", flag, value, min)
+	}
+	return nil
+}
+
+// secretFlags hold credentials and are never reported.
+var secretFlags = map[string]bool{
+	"api-keys":      true,
+	"cookie-secret": true,
+}
+
+// flagValues returns the value of every flag in fs, set or defaulted,
+// with secrets redacted. Passwords in URLs are redacted too.
+func flagValues(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		switch {
+		case v == "":
+		case secretFlags[f.Name]:
+			v = "[redacted]"
+		default:
+			if u, err := url.Parse(v); err == nil && u.User != nil {
+				v = u.Redacted()
+			}
+		}
+		values[f.Name] = v
+	})
+	return values
+}
+
+// configHandler reports the effective configuration as JSON.
+func configHandler(settings map[string]any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// sessionCookie names the cookie that carries the session of a browser
+// client that does not send a channel or node_id.
+const sessionCookie = "chatty_session"
+
+// cookieSigner signs session cookies with HMAC-SHA256 so that clients
+// cannot pick another session by editing theirs. New cookies are signed
+// with the first secret, and any of the secrets is accepted, so a secret
+// can be rotated by putting the new one first. With no secrets, cookies
+// are not signed.
+type cookieSigner struct {
+	secrets [][]byte
+}
+
+func (s *cookieSigner) mac(secret []byte, id string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// sign returns the cookie value for a session.
+func (s *cookieSigner) sign(id string) string {
+	if len(s.secrets) == 0 {
+		return id
+	}
+	return id + "." + s.mac(s.secrets[0], id)
+}
+
+// verify returns the session of a cookie value, or false if it is not
+// signed by one of the secrets.
+func (s *cookieSigner) verify(value string) (string, bool) {
+	if len(s.secrets) == 0 {
+		return value, value != ""
+	}
+	id, sig, ok := strings.Cut(value, ".")
+	if !ok || id == "" {
+		return "", false
+	}
+	for _, secret := range s.secrets {
+		if hmac.Equal([]byte(sig), []byte(s.mac(secret, id))) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+type cookieSessionKey struct{}
+
+// cookieSession returns the session from a verified cookie, if any.
+func cookieSession(ctx context.Context) string {
+	id, _ := ctx.Value(cookieSessionKey{}).(string)
+	return id
+}
+
+// sessionCookies verifies the session cookie and passes its session to
+// next in the request context. A tampered cookie is ignored. When issue is
+// set, as for the browser UI, a client with no valid cookie, channel, or
+// node_id is given a new session cookie. API clients are not, since one
+// without a cookie jar would start a new session on every call.
+func sessionCookies(signer *cookieSigner, issue bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var id string
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			var ok bool
+			if id, ok = signer.verify(c.Value); !ok {
+				slog.Warn("rejected session cookie with a bad signature", "remote_addr", r.RemoteAddr)
+			}
+		}
+		q := r.URL.Query()
+		if issue && id == "" && q.Get("channel") == "" && q.Get("node_id") == "" {
+			id = rand.Text()
+			http.SetCookie(w, &http.Cookie{
+				Name:     sessionCookie,
+				Value:    signer.sign(id),
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+		if id != "" {
+			r = r.WithContext(context.WithValue(r.Context(), cookieSessionKey{}, id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCookieSigner(t *testing.T) {
+	old := &cookieSigner{secrets: [][]byte{[]byte("old")}}
+	rotated := &cookieSigner{secrets: [][]byte{[]byte("new"), []byte("old")}}
+	unsigned := &cookieSigner{}
+	tests := []struct {
+		name   string
+		signer *cookieSigner
+		value  string
+		want   string // empty when the value is rejected
+	}{
+		{"signed", old, old.sign("abc"), "abc"},
+		{"rotated, old secret", rotated, old.sign("abc"), "abc"},
+		{"rotated, new secret", rotated, rotated.sign("abc"), "abc"},
+		{"retired secret", old, rotated.sign("abc"), ""},
+		{"tampered id", old, "xyz" + old.sign("abc")[3:], ""},
+		{"tampered signature", old, old.sign("abc") + "x", ""},
+		{"unsigned value", old, "abc", ""},
+		{"no id", old, "." + old.mac([]byte("old"), ""), ""},
+		{"empty", old, "", ""},
+		{"without secrets", unsigned, unsigned.sign("abc"), "abc"},
+		{"without secrets, empty", unsigned, "", ""},
+	}
+	for _, tt := range tests {
+		got, ok := tt.signer.verify(tt.value)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("%s: verify(%q) = %q, %v; want %q", tt.name, tt.value, got, ok, tt.want)
+		}
+	}
+	if v := unsigned.sign("abc"); v != "abc" {
+		t.Errorf("sign without secrets = %q, want abc", v)
+	}
+}
+
+func TestSessionCookies(t *testing.T) {
+	signer := &cookieSigner{secrets: [][]byte{[]byte("secret")}}
+	tests := []struct {
+		name      string
+		issue     bool
+		query     string
+		cookie    string
+		wantID    string // "new" for a newly issued session
+		wantIssue bool
+	}{
+		{"ui, no cookie", true, "", "", "new", true},
+		{"ui, valid cookie", true, "", signer.sign("abc"), "abc", false},
+		{"ui, tampered cookie", true, "", "abc.bad", "new", true},
+		{"ui, node_id", true, "node_id=n1", "", "", false},
+		{"ui, channel", true, "channel=2", "", "", false},
+		{"api, no cookie", false, "", "", "", false},
+		{"api, valid cookie", false, "", signer.sign("abc"), "abc", false},
+		{"api, tampered cookie", false, "", "abc.bad", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotID string
+			h := sessionCookies(signer, tt.issue, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotID = cookieSession(r.Context())
+			}))
+			req := httptest.NewRequest("GET", "/?"+tt.query, nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: sessionCookie, Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			cookies := rec.Result().Cookies()
+			if issued := len(cookies) > 0; issued != tt.wantIssue {
+				t.Fatalf("issued cookies %v, want %v", cookies, tt.wantIssue)
+			}
+			if tt.wantID == "new" {
+				c := cookies[0]
+				id, ok := signer.verify(c.Value)
+				if !ok || id == "" || id != gotID {
+					t.Errorf("issued %q for session %q", c.Value, gotID)
+				}
+				if !c.HttpOnly || c.SameSite != http.SameSiteStrictMode || c.Path != "/" {
+					t.Errorf("cookie attributes = %+v", c)
+				}
+				return
+			}
+			if gotID != tt.wantID {
+				t.Errorf("session = %q, want %q", gotID, tt.wantID)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"maps"
+	"slices"
+	"strings"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// echoModel is a model.LLM used for -dry-run. It replies with the latest
+// user message and the session metadata instead of calling an API.
+type echoModel struct{}
+
+func (echoModel) Name() string {
+	return "dry-run"
+}
+
+func (echoModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		var msg string
+		for _, c := range slices.Backward(req.Contents) {
+			if c != nil && c.Role == "user" {
+				for _, p := range c.Parts {
+					msg += p.Text
+				}
+				break
+			}
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "[dry-run] %s", msg)
+		if ictx, ok := ctx.(agent.InvocationContext); ok {
+			state := maps.Collect(ictx.Session().State().All())
+			for _, k := range slices.Sorted(maps.Keys(state)) {
+				fmt.Fprintf(&b, " %s=%v", k, state[k])
+			}
+		}
+
+		yield(&model.LLMResponse{
+			Content:      genai.NewContentFromText(b.String(), genai.RoleModel),
+			FinishReason: genai.FinishReasonStop,
+			ModelVersion: "dry-run",
+			TurnComplete: true,
+		}, nil)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"google.golang.org/adk/session"
+)
+
+// TestDryRun serves a chat with -dry-run while the API keys and base URL
+// point at a fake Gemini API, which must not be called.
+func TestDryRun(t *testing.T) {
+	g := newFakeGemini(t)
+	cfg, _ := testConfig(t, "-dry-run", "-api-keys", "k1", "-base-url", g.URL+"/")
+	llm, err := newLLM(t.Context(), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := llm.(echoModel); !ok {
+		t.Fatalf("newLLM returned %T, want echoModel", llm)
+	}
+
+	sessionService := session.InMemoryService()
+	h := chatHandler(cfg, newTestRunner(t, cfg, sessionService, llm), sessionService, nil, nil, nil, nil, nil)
+	c := &testChat{Handler: h, cfg: cfg, sessionService: sessionService}
+
+	tests := []struct {
+		params []string
+		want   string
+	}{
+		{[]string{"msg", "hello", "node_id", "n1"}, "[dry-run] hello node_id=n1"},
+		{[]string{"msg", "hi", "node_id", "n2", "short_name", "ab", "hops", "2"}, "[dry-run] hi hops=2 node_id=n2 short_name=ab"},
+	}
+	for _, tt := range tests {
+		rec := c.get(t, nil, tt.params...)
+		if rec.Code != http.StatusOK || rec.Body.String() != tt.want {
+			t.Errorf("got %d %q, want %q", rec.Code, rec.Body, tt.want)
+		}
+		if got := rec.Header().Get("X-Model"); got != "dry-run" {
+			t.Errorf("X-Model = %q, want dry-run", got)
+		}
+	}
+	if calls := g.calls(); len(calls) != 0 {
+		t.Errorf("the Gemini API was called %d times in dry-run", len(calls))
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"google.golang.org/genai"
+)
+
+// maxEmbedBatch is the largest number of texts sent in one EmbedContent
+// call. Larger requests are split into chunks of this size.
+const maxEmbedBatch = 100
+
+// maxEmbedTexts bounds the number of texts accepted by /embed.
+const maxEmbedTexts = 1000
+
+type embedRequest struct {
+	Texts []string `json:"texts"`
+	Model string   `json:"model"`
+}
+
+type embedResponse struct {
+	Model      string      `json:"model"`
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// embedHandler returns text embeddings computed by the Gemini API.
+func embedHandler(client *genai.Client, defaultModel string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req embedRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_json", "body must be a JSON object with a texts array")
+			return
+		}
+		if len(req.Texts) == 0 {
+			writeError(w, http.StatusBadRequest, "missing_texts", "texts is required")
+			return
+		}
+		if len(req.Texts) > maxEmbedTexts {
+			writeError(w, http.StatusRequestEntityTooLarge, "too_many_items", "at most "+strconv.Itoa(maxEmbedTexts)+" texts are allowed")
+			return
+		}
+		if req.Model == "" {
+			req.Model = defaultModel
+		}
+
+		resp := embedResponse{
+			Model:      req.Model,
+			Embeddings: make([][]float32, 0, len(req.Texts)),
+		}
+		for chunk := range slices.Chunk(req.Texts, maxEmbedBatch) {
+			contents := make([]*genai.Content, len(chunk))
+			for i, text := range chunk {
+				contents[i] = genai.NewContentFromText(text, genai.RoleUser)
+			}
+			result, err := client.Models.EmbedContent(r.Context(), req.Model, contents, nil)
+			if err != nil {
+				slog.Error("failed to embed content", "model", req.Model, "error", err)
+				writeError(w, http.StatusBadGateway, "upstream_error", "failed to compute embeddings")
+				return
+			}
+			for _, e := range result.Embeddings {
+				resp.Embeddings = append(resp.Embeddings, e.Values)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+}
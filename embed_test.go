@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// newEmbedServer returns an /embed handler backed by a fake Gemini API
+// whose embedding of the text "tN" is [N, -N].
+func newEmbedServer(t *testing.T) (http.Handler, *fakeGemini) {
+	t.Helper()
+	g := newFakeGemini(t)
+	g.respond = func(req fakeGeminiRequest) (int, any) {
+		if req.Method != "batchEmbedContents" {
+			return http.StatusNotFound, geminiError(http.StatusNotFound, "NOT_FOUND")
+		}
+		var embeddings []any
+		for _, r := range req.Body["requests"].([]any) {
+			parts := r.(map[string]any)["content"].(map[string]any)["parts"].([]any)
+			text := parts[0].(map[string]any)["text"].(string)
+			if text == "fail" {
+				return http.StatusInternalServerError, geminiError(http.StatusInternalServerError, "INTERNAL")
+			}
+			n, _ := strconv.Atoi(strings.TrimPrefix(text, "t"))
+			embeddings = append(embeddings, map[string]any{"values": []float32{float32(n), float32(-n)}})
+		}
+		return http.StatusOK, map[string]any{"embeddings": embeddings}
+	}
+	client, err := genai.NewClient(context.Background(), newClientConfig("k", nil, g.URL+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return embedHandler(client, "default-embed"), g
+}
+
+func TestEmbed(t *testing.T) {
+	h, g := newEmbedServer(t)
+	texts := make([]string, 250)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("t%d", i)
+	}
+	body, _ := json.Marshal(embedRequest{Texts: texts})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/embed", strings.NewReader(string(body))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp embedResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Model != "default-embed" {
+		t.Errorf("model = %q, want default-embed", resp.Model)
+	}
+	if len(resp.Embeddings) != len(texts) {
+		t.Fatalf("got %d embeddings, want %d", len(resp.Embeddings), len(texts))
+	}
+	for i, e := range resp.Embeddings {
+		if len(e) != 2 || e[0] != float32(i) || e[1] != float32(-i) {
+			t.Fatalf("embedding %d = %v, want [%d %d]", i, e, i, -i)
+		}
+	}
+	var sizes []int
+	for _, c := range g.calls() {
+		sizes = append(sizes, len(c.Body["requests"].([]any)))
+		if c.Model != "default-embed" {
+			t.Errorf("called model %q", c.Model)
+		}
+	}
+	if fmt.Sprint(sizes) != "[100 100 50]" {
+		t.Errorf("chunk sizes = %v, want [100 100 50]", sizes)
+	}
+}
+
+func TestEmbedErrors(t *testing.T) {
+	h, _ := newEmbedServer(t)
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"not JSON", "texts", http.StatusBadRequest, "invalid_json"},
+		{"no texts", `{"texts":[]}`, http.StatusBadRequest, "missing_texts"},
+		{"too many", `{"texts":[` + strings.TrimSuffix(strings.Repeat(`"t",`, maxEmbedTexts+1), ",") + `]}`, http.StatusRequestEntityTooLarge, "too_many_items"},
+		{"upstream", `{"texts":["t1","fail"],"model":"m"}`, http.StatusBadGateway, "upstream_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/embed", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if code := decodeError(t, rec); code != tt.wantCode {
+				t.Errorf("code = %q, want %q", code, tt.wantCode)
+			}
+		})
+	}
+}
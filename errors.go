@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type errorBody struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// writeError sends a JSON error of the form
+// {"error":{"code":"...","message":"..."}} with the given status.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	var body errorBody
+	body.Error.Code = code
+	body.Error.Message = message
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeTextError sends message as a plain text error. It has the same
+// signature as writeError so handlers can choose between them.
+func writeTextError(w http.ResponseWriter, status int, code, message string) {
+	http.Error(w, message, status)
+}
+
+// errorFunc sends an error response; see writeError and writeTextError.
+type errorFunc func(w http.ResponseWriter, status int, code, message string)
+
+// errorWriter returns the errorFunc for an -error-format.
+func errorWriter(format string) errorFunc {
+	if format == "text" {
+		return writeTextError
+	}
+	return writeError
+}
+
+// apiError describes an error to report to a client.
+type apiError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+func TestWriteError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeError(rec, http.StatusTeapot, "teapot", "short and stout")
+	if rec.Code != http.StatusTeapot {
+		t.Errorf("status = %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if got, want := rec.Body.String(), `{"error":{"code":"teapot","message":"short and stout"}}`+"\n"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
+
+// decodeError returns the code of a JSON error body, failing the test if
+// the body does not have that shape.
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var body errorBody
+	dec := json.NewDecoder(rec.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		t.Fatalf("body is not a JSON error: %v", err)
+	}
+	if body.Error.Code == "" || body.Error.Message == "" {
+		t.Errorf("error = %+v, want a code and a message", body.Error)
+	}
+	return body.Error.Code
+}
+
+func TestChatErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		reply      fakeReply
+		header     http.Header
+		params     []string
+		wantStatus int
+		wantCode   string
+	}{
+		{"missing msg", textReply("ok"), nil, nil, http.StatusBadRequest, "missing_msg"},
+		{"too large", textReply("ok"), nil, []string{"msg", strings.Repeat("x", 5000)}, http.StatusRequestEntityTooLarge, "input_too_large"},
+		{"reserved session", textReply("ok"), nil, []string{"msg", "hi", "node_id", "batch-1"}, http.StatusBadRequest, "invalid_session"},
+		{"bad format", textReply("ok"), nil, []string{"msg", "hi", "format", "pdf"}, http.StatusBadRequest, "invalid_format"},
+		{"bad alternatives", textReply("ok"), nil, []string{"msg", "hi", "alternatives", "maybe"}, http.StatusBadRequest, "invalid_parameter"},
+		{"bad timezone", textReply("ok"), http.Header{"X-Timezone": {"Mars/Olympus"}}, []string{"msg", "hi"}, http.StatusBadRequest, "invalid_timezone"},
+		{"upstream error", fakeReply{err: genai.APIError{Code: 500}}, nil, []string{"msg", "hi"}, http.StatusInternalServerError, "upstream_error"},
+		{"circuit open", fakeReply{err: errCircuitOpen}, nil, []string{"msg", "hi"}, http.StatusServiceUnavailable, "unavailable"},
+		{"no code block", textReply("no code"), nil, []string{"msg", "hi", "format", "code"}, http.StatusUnprocessableEntity, "no_code"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestChat(t, &fakeModel{name: "fake", replies: []fakeReply{tt.reply}})
+			rec := c.get(t, tt.header, tt.params...)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if code := decodeError(t, rec); code != tt.wantCode {
+				t.Errorf("code = %q, want %q", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestChatTextErrors(t *testing.T) {
+	c := newTestChat(t, &fakeModel{name: "fake", replies: []fakeReply{{err: errors.New("boom")}}}, "-error-format", "text")
+	tests := []struct {
+		params     []string
+		wantStatus int
+		wantBody   string
+	}{
+		{nil, http.StatusBadRequest, "msg query parameter is required\n"},
+		{[]string{"msg", "hi"}, http.StatusInternalServerError, "failed to get response from AI\n"},
+	}
+	for _, tt := range tests {
+		rec := c.get(t, nil, tt.params...)
+		if rec.Code != tt.wantStatus || rec.Body.String() != tt.wantBody {
+			t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body, tt.wantStatus, tt.wantBody)
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			t.Errorf("Content-Type = %q, want text/plain", ct)
+		}
+	}
+}
+
+func TestAdminErrors(t *testing.T) {
+	_, mux, _ := newTestAdmin(t)
+	tests := []struct {
+		method, path, token, body string
+		wantStatus                int
+		wantCode                  string
+	}{
+		{http.MethodGet, "/admin/sessions", "", "", http.StatusUnauthorized, "unauthorized"},
+		{http.MethodDelete, "/admin/sessions/none", testAdminToken, "", http.StatusNotFound, "not_found"},
+		{http.MethodPost, "/admin/sessions/none/model", testAdminToken, "[", http.StatusBadRequest, "invalid_json"},
+		{http.MethodPost, "/admin/sessions/none/model", testAdminToken, `{"model":"m"}`, http.StatusNotFound, "not_found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := adminRequest(mux, tt.method, tt.path, tt.token, strings.NewReader(tt.body))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if code := decodeError(t, rec); code != tt.wantCode {
+				t.Errorf("code = %q, want %q", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestHealthErrors(t *testing.T) {
+	var draining atomic.Bool
+	draining.Store(true)
+	tests := []struct {
+		name string
+		h    http.Handler
+	}{
+		{"readyz", readyzHandler(&draining)},
+		{"rejectWhenDraining", rejectWhenDraining(&draining, writeError, http.NotFoundHandler())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Errorf("status = %d, want 503", rec.Code)
+			}
+			if code := decodeError(t, rec); code != "draining" {
+				t.Errorf("code = %q, want draining", code)
+			}
+		})
+	}
+}
+
+// TestWrapperTextErrors checks that the wrappers in front of the chat
+// handler follow -error-format=text too.
+func TestWrapperTextErrors(t *testing.T) {
+	c := newTestChat(t, echoModel{}, "-error-format", "text")
+	mux := http.NewServeMux()
+	var draining atomic.Bool
+	draining.Store(true)
+	registerChat(mux, c.cfg, &draining, &cookieSigner{}, c, http.NotFoundHandler())
+
+	// Fill a queue of one behind a busy worker.
+	busy := newGatedHandler()
+	defer close(busy.release)
+	q := newAdmissionQueue(1, 1, time.Minute, errorWriter(c.cfg.ErrorFormat))
+	queue := q.middleware(busy)
+	go queue.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?id=busy", nil))
+	<-busy.started
+	go queue.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?id=queued", nil))
+	queueDepth(t, q, 1)
+
+	tests := []struct {
+		name     string
+		h        http.Handler
+		wantBody string
+	}{
+		{"draining", mux, "the server is not accepting new requests\n"},
+		{"queue full", queue, "the server is busy; try again later\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?msg=hi", nil))
+			if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != tt.wantBody {
+				t.Errorf("got %d %q, want 503 %q", rec.Code, rec.Body, tt.wantBody)
+			}
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+				t.Errorf("Content-Type = %q, want text/plain", ct)
+			}
+		})
+	}
+}
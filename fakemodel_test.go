@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"iter"
+	"sync"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// fakeModel answers each call with the next of its replies, repeating the
+// last one once they run out. A reply with a non-nil err fails the call.
+type fakeModel struct {
+	name    string
+	replies []fakeReply
+
+	mu    sync.Mutex
+	calls []*model.LLMRequest
+}
+
+type fakeReply struct {
+	resps []*model.LLMResponse
+	err   error
+}
+
+// textReply is a fakeReply with a single response holding text.
+func textReply(text string) fakeReply {
+	return fakeReply{resps: []*model.LLMResponse{{
+		Content:      genai.NewContentFromText(text, genai.RoleModel),
+		FinishReason: genai.FinishReasonStop,
+	}}}
+}
+
+func (m *fakeModel) Name() string { return m.name }
+
+func (m *fakeModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		m.mu.Lock()
+		i := min(len(m.calls), len(m.replies)-1)
+		m.calls = append(m.calls, req)
+		m.mu.Unlock()
+
+		r := m.replies[i]
+		for _, resp := range r.resps {
+			if !yield(resp, nil) {
+				return
+			}
+		}
+		if r.err != nil {
+			yield(nil, r.err)
+		}
+	}
+}
+
+// numCalls returns how many times the model was called.
+func (m *fakeModel) numCalls() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.calls)
+}
+
+// drain runs a call to m and returns its last error.
+func drain(m model.LLM, req *model.LLMRequest) error {
+	var last error
+	for _, err := range m.GenerateContent(context.Background(), req, false) {
+		if err != nil {
+			last = err
+		}
+	}
+	return last
+}
+
+// blockingModel answers no call until the call's context is done. started
+// receives a value as each call begins.
+type blockingModel struct {
+	started chan struct{}
+}
+
+func (blockingModel) Name() string { return "blocking" }
+
+func (m blockingModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		m.started <- struct{}{}
+		<-ctx.Done()
+		yield(nil, ctx.Err())
+	}
+}
+
+// funcModel answers each call with the reply returned by the function.
+type funcModel func(req *model.LLMRequest) fakeReply
+
+func (funcModel) Name() string { return "func" }
+
+func (f funcModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return (&fakeModel{replies: []fakeReply{f(req)}}).GenerateContent(ctx, req, stream)
+}
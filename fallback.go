@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"log/slog"
+	"net/http"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// googleLLM matches the ADK's internal interface for detecting the Gemini
+// backend, so that wrapped models keep backend-specific behavior.
+type googleLLM interface {
+	GetGoogleLLMVariant() genai.Backend
+}
+
+// googleLLMVariant returns the backend of m, for a wrapper of m to report
+// as its own.
+func googleLLMVariant(m model.LLM) genai.Backend {
+	if g, ok := m.(googleLLM); ok {
+		return g.GetGoogleLLMVariant()
+	}
+	return genai.BackendUnspecified
+}
+
+// fallbackModel sends requests to a chain of models, moving on to the next
+// model when one fails with a retryable error before producing any output.
+type fallbackModel struct {
+	models []model.LLM
+}
+
+func (m *fallbackModel) Name() string {
+	return m.models[0].Name()
+}
+
+func (m *fallbackModel) GetGoogleLLMVariant() genai.Backend {
+	return googleLLMVariant(m.models[0])
+}
+
+func (m *fallbackModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		for i, llm := range m.models {
+			// The ADK sets the request model to our name, so point it at
+			// the model actually being tried. A different name, chosen
+			// for the session, takes the place of the first model.
+			r := *req
+			if i > 0 || req.Model == "" || req.Model == m.Name() {
+				r.Model = llm.Name()
+			}
+
+			var failed error
+			started := false
+			for resp, err := range llm.GenerateContent(ctx, &r, stream) {
+				if err != nil && !started && i < len(m.models)-1 && isRetryable(err) {
+					failed = err
+					break
+				}
+				started = true
+				if resp != nil && resp.ModelVersion == "" {
+					resp.ModelVersion = r.Model
+				}
+				if !yield(resp, err) {
+					return
+				}
+			}
+			if failed == nil {
+				return
+			}
+			slog.Warn("model unavailable, falling back", "model", r.Model, "fallback", m.models[i+1].Name(), "error", failed)
+		}
+	}
+}
+
+// isRetryable reports whether err is an upstream overload or rate limit
+// that another model may not be subject to.
+func isRetryable(err error) bool {
+	var apiErr genai.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Code == http.StatusTooManyRequests || apiErr.Code == http.StatusServiceUnavailable
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+func TestFallbackModel(t *testing.T) {
+	unavailable := fakeReply{err: genai.APIError{Code: http.StatusServiceUnavailable}}
+	rateLimited := fakeReply{err: genai.APIError{Code: http.StatusTooManyRequests}}
+	badRequest := fakeReply{err: genai.APIError{Code: http.StatusBadRequest}}
+	midStream := fakeReply{resps: textReply("partial").resps, err: genai.APIError{Code: http.StatusServiceUnavailable}}
+
+	tests := []struct {
+		name      string
+		primary   fakeReply
+		fallback  fakeReply
+		wantModel string
+		wantErr   bool
+		wantCalls [2]int
+	}{
+		{"primary succeeds", textReply("ok"), textReply("ok"), "primary", false, [2]int{1, 0}},
+		{"overloaded", unavailable, textReply("ok"), "fallback", false, [2]int{1, 1}},
+		{"rate limited", rateLimited, textReply("ok"), "fallback", false, [2]int{1, 1}},
+		{"not retryable", badRequest, textReply("ok"), "", true, [2]int{1, 0}},
+		{"failed after output", midStream, textReply("ok"), "primary", true, [2]int{1, 0}},
+		{"all fail", unavailable, unavailable, "", true, [2]int{1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &fakeModel{name: "primary", replies: []fakeReply{tt.primary}}
+			fallback := &fakeModel{name: "fallback", replies: []fakeReply{tt.fallback}}
+			m := &fallbackModel{models: []model.LLM{primary, fallback}}
+
+			var gotModel string
+			var gotErr error
+			for resp, err := range m.GenerateContent(t.Context(), &model.LLMRequest{Model: "primary"}, false) {
+				if err != nil {
+					gotErr = err
+					continue
+				}
+				gotModel = resp.ModelVersion
+			}
+			if (gotErr != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", gotErr, tt.wantErr)
+			}
+			if gotModel != tt.wantModel {
+				t.Errorf("model version = %q, want %q", gotModel, tt.wantModel)
+			}
+			if calls := [2]int{primary.numCalls(), fallback.numCalls()}; calls != tt.wantCalls {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+			if n := fallback.numCalls(); n > 0 && fallback.calls[0].Model != "fallback" {
+				t.Errorf("fallback asked for model %q", fallback.calls[0].Model)
+			}
+		})
+	}
+}
+
+func TestFallbackModelSessionModel(t *testing.T) {
+	primary := &fakeModel{name: "primary", replies: []fakeReply{{err: genai.APIError{Code: http.StatusServiceUnavailable}}}}
+	fallback := &fakeModel{name: "fallback", replies: []fakeReply{textReply("ok")}}
+	m := &fallbackModel{models: []model.LLM{primary, fallback}}
+	if err := drain(m, &model.LLMRequest{Model: "chosen"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := primary.calls[0].Model; got != "chosen" {
+		t.Errorf("first model asked for %q, want the session's model", got)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{genai.APIError{Code: 503}, true},
+		{genai.APIError{Code: 429}, true},
+		{genai.APIError{Code: 500}, false},
+		{genai.APIError{Code: 400}, false},
+		{errors.New("network"), false},
+	}
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// TestChatFallback sends two messages in a session whose primary model is
+// overloaded. The fallback serves both, reports itself in X-Model, and
+// gets the history of the first turn with the second message.
+func TestChatFallback(t *testing.T) {
+	primary := &fakeModel{name: "primary", replies: []fakeReply{{err: genai.APIError{Code: http.StatusServiceUnavailable}}}}
+	fallback := &fakeModel{name: "fallback", replies: []fakeReply{textReply("first"), textReply("second")}}
+	c := newTestChat(t, &fallbackModel{models: []model.LLM{primary, fallback}})
+
+	for _, msg := range []string{"one", "two"} {
+		rec := c.get(t, nil, "msg", msg, "node_id", "n")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("X-Model"); got != "fallback" {
+			t.Errorf("X-Model = %q, want fallback", got)
+		}
+	}
+	history := fallback.calls[1].Contents
+	if len(history) != 3 || history[0].Parts[0].Text != "one" || history[1].Parts[0].Text != "first" {
+		t.Errorf("second call contents = %d items, want the first turn and the new message", len(history))
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"iter"
+	"maps"
+	"net/http"
+	"strings"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/model/gemini"
+	"google.golang.org/genai"
+)
+
+// safetyRatingsKey is the LLMResponse custom metadata key holding the
+// safety ratings of a blocked prompt.
+const safetyRatingsKey = "safety_ratings"
+
+// promptFeedbackModel recovers what the ADK Gemini model drops from
+// non-streaming responses: the block reason of a prompt refused before any
+// candidate was written, which the ADK reports only as an "empty response"
+// error, and the candidates after the first. Calls still go through the
+// ADK model; the raw response body is captured on the way back by
+// captureTransport. Streaming calls already report blocked prompts and are
+// passed through.
+type promptFeedbackModel struct {
+	model.LLM
+}
+
+// newGeminiModel creates an ADK Gemini model whose prompt feedback and
+// extra candidates are kept.
+func newGeminiModel(ctx context.Context, name string, cc *genai.ClientConfig) (model.LLM, error) {
+	cc2 := *cc
+	var client http.Client
+	if cc.HTTPClient != nil {
+		client = *cc.HTTPClient
+	}
+	client.Transport = &captureTransport{base: client.Transport}
+	cc2.HTTPClient = &client
+	m, err := gemini.NewModel(ctx, name, &cc2)
+	if err != nil {
+		return nil, err
+	}
+	return &promptFeedbackModel{LLM: m}, nil
+}
+
+func (m *promptFeedbackModel) GetGoogleLLMVariant() genai.Backend {
+	return googleLLMVariant(m.LLM)
+}
+
+func (m *promptFeedbackModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	if stream {
+		return m.LLM.GenerateContent(ctx, req, stream)
+	}
+	return func(yield func(*model.LLMResponse, error) bool) {
+		var body capturedBody
+		for resp, err := range m.LLM.GenerateContent(context.WithValue(ctx, capturedBodyKey{}, &body), req, false) {
+			raw := body.response()
+			switch {
+			case err != nil && raw != nil && len(raw.Candidates) == 0 && raw.PromptFeedback != nil && raw.PromptFeedback.BlockReason != "":
+				fb := raw.PromptFeedback
+				resp, err = &model.LLMResponse{
+					ErrorCode:      string(fb.BlockReason),
+					ErrorMessage:   fb.BlockReasonMessage,
+					UsageMetadata:  raw.UsageMetadata,
+					ModelVersion:   raw.ModelVersion,
+					CustomMetadata: map[string]any{safetyRatingsKey: fb.SafetyRatings},
+				}, nil
+			case err == nil && resp != nil && raw != nil && len(raw.Candidates) > 1:
+				out := *resp
+				out.CustomMetadata = maps.Clone(resp.CustomMetadata)
+				if out.CustomMetadata == nil {
+					out.CustomMetadata = make(map[string]any)
+				}
+				out.CustomMetadata[alternativesKey] = candidateAlternatives(raw.Candidates)
+				resp = &out
+			}
+			if !yield(resp, err) {
+				return
+			}
+		}
+	}
+}
+
+type capturedBodyKey struct{}
+
+// capturedBody holds the body of a non-streaming generateContent response.
+type capturedBody struct {
+	data []byte
+}
+
+// response decodes the captured body, or returns nil if there is none or
+// it is not a response.
+func (b *capturedBody) response() *genai.GenerateContentResponse {
+	if b.data == nil {
+		return nil
+	}
+	var resp genai.GenerateContentResponse
+	if err := json.Unmarshal(b.data, &resp); err != nil {
+		return nil
+	}
+	return &resp
+}
+
+// captureTransport copies the body of successful non-streaming
+// generateContent responses into the request context's capturedBody, if it
+// has one. The body is passed on unchanged.
+type captureTransport struct {
+	base http.RoundTripper
+}
+
+func (t *captureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	body, ok := req.Context().Value(capturedBodyKey{}).(*capturedBody)
+	if err != nil || !ok || resp.StatusCode != http.StatusOK || !strings.HasSuffix(req.URL.Path, ":generateContent") {
+		return resp, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body.data = data
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return resp, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/model/gemini"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// geminiBlocked is a response to a prompt blocked for reason, with no
+// candidates.
+func geminiBlocked(reason string) map[string]any {
+	return map[string]any{
+		"promptFeedback": map[string]any{
+			"blockReason":        reason,
+			"blockReasonMessage": "not allowed",
+			"safetyRatings": []any{
+				map[string]any{"category": "HARM_CATEGORY_HARASSMENT", "probability": "HIGH", "blocked": true},
+				map[string]any{"category": "HARM_CATEGORY_HATE_SPEECH", "probability": "NEGLIGIBLE"},
+			},
+		},
+		"modelVersion": "fake-1",
+	}
+}
+
+func TestChatPromptFeedback(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     map[string]any
+		status   int
+		wantCode string
+		want     string // in the reply or error message
+	}{
+		{"blocked", geminiBlocked("SAFETY"), http.StatusUnprocessableEntity, "prompt_blocked", "(SAFETY): not allowed; ratings: HARM_CATEGORY_HARASSMENT=HIGH"},
+		{"no candidates", map[string]any{"modelVersion": "fake-1"}, http.StatusInternalServerError, "upstream_error", ""},
+		{"answered", geminiText("Hello"), http.StatusOK, "", "Hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newFakeGemini(t)
+			g.respond = func(fakeGeminiRequest) (int, any) { return http.StatusOK, tt.body }
+			cfg, _ := testConfig(t, "-api-keys", "k1", "-base-url", g.URL+"/")
+			llm, err := buildModel(t.Context(), cfg, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sessionService := session.InMemoryService()
+			chat := chatHandler(cfg, newTestRunner(t, cfg, sessionService, llm), sessionService, nil, nil, nil, nil, nil)
+			rec := httptest.NewRecorder()
+			chat.ServeHTTP(rec, httptest.NewRequest("GET", "/?node_id=n1&msg=hi", nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusOK {
+				if rec.Body.String() != tt.want {
+					t.Errorf("reply = %q, want %q", rec.Body, tt.want)
+				}
+				return
+			}
+			body := rec.Body.String()
+			if code := decodeError(t, rec); code != tt.wantCode {
+				t.Errorf("code = %q, want %q", code, tt.wantCode)
+			}
+			if !strings.Contains(body, tt.want) {
+				t.Errorf("body = %s, want it to contain %q", body, tt.want)
+			}
+			if strings.Contains(body, "HATE_SPEECH") {
+				t.Errorf("body = %s, lists a negligible rating", body)
+			}
+		})
+	}
+}
+
+// TestPromptFeedbackModel checks the response for a blocked prompt, and
+// that streaming calls go to the ADK model.
+func TestPromptFeedbackModel(t *testing.T) {
+	g := newFakeGemini(t)
+	g.respond = func(fakeGeminiRequest) (int, any) { return http.StatusOK, geminiBlocked("BLOCKLIST") }
+	cfg, _ := testConfig(t, "-api-keys", "k1", "-base-url", g.URL+"/")
+	llm, err := buildModel(t.Context(), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := llm.(*promptFeedbackModel); !ok {
+		t.Fatalf("buildModel returned %T, want *promptFeedbackModel", llm)
+	}
+	var resps []*model.LLMResponse
+	for resp, err := range llm.GenerateContent(t.Context(), geminiRequest("hi"), false) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		resps = append(resps, resp)
+	}
+	if len(resps) != 1 {
+		t.Fatalf("got %d responses, want 1", len(resps))
+	}
+	resp := resps[0]
+	ratings, _ := resp.CustomMetadata[safetyRatingsKey].([]*genai.SafetyRating)
+	if resp.ErrorCode != "BLOCKLIST" || resp.ErrorMessage != "not allowed" || resp.Content != nil || len(ratings) != 2 {
+		t.Errorf("response = %+v, want BLOCKLIST with two ratings", resp)
+	}
+
+	for range llm.GenerateContent(t.Context(), geminiRequest("hi"), true) {
+	}
+	calls := g.calls()
+	if len(calls) != 2 || calls[0].Stream || !calls[1].Stream {
+		t.Fatalf("requests = %+v, want one call then one streaming call", calls)
+	}
+	// Both calls are made by the ADK model, which names itself.
+	for i, call := range calls {
+		if got := call.Header.Get("x-goog-api-client"); !strings.HasPrefix(got, "google-adk/") {
+			t.Errorf("call %d: x-goog-api-client = %q, want the ADK's", i+1, got)
+		}
+	}
+}
+
+// TestADKGeminiModel pins the ADK behavior that promptFeedbackModel
+// depends on: a non-streaming response without candidates is an error,
+// and only the first of several candidates is returned.
+func TestADKGeminiModel(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    map[string]any
+		wantErr bool
+		want    string
+	}{
+		{"blocked prompt", geminiBlocked("SAFETY"), true, ""},
+		{"several candidates", geminiCandidates("one", "two", "three"), false, "one"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newFakeGemini(t)
+			g.respond = func(fakeGeminiRequest) (int, any) { return http.StatusOK, tt.body }
+			m, err := gemini.NewModel(t.Context(), "test-model", newClientConfig("k1", nil, g.URL+"/"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var texts []string
+			var gotErr error
+			for resp, err := range m.GenerateContent(t.Context(), geminiRequest("hi"), false) {
+				if err != nil {
+					gotErr = err
+					continue
+				}
+				for _, p := range resp.Content.Parts {
+					texts = append(texts, p.Text)
+				}
+			}
+			if (gotErr != nil) != tt.wantErr || strings.Join(texts, "") != tt.want {
+				t.Errorf("got %q, error %v; want %q, error %v", texts, gotErr, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// maxMessageFiles bounds the number of files attached to one message.
+const maxMessageFiles = 10
+
+type fileResponse struct {
+	Name      string    `json:"name"`
+	URI       string    `json:"uri"`
+	MIMEType  string    `json:"mime_type"`
+	SizeBytes int64     `json:"size_bytes,omitempty"`
+	Expires   time.Time `json:"expires,omitzero"`
+}
+
+// fileStore uploads files to the Gemini Files API so they can be attached
+// to later messages by name. The API deletes files when they expire.
+type fileStore struct {
+	client  *genai.Client
+	maxSize int64
+}
+
+// uploadHandler uploads the request body as a file of the request's
+// Content-Type. The optional display_name query parameter names it.
+func (s *fileStore) uploadHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mimeType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			writeError(w, http.StatusUnsupportedMediaType, "missing_content_type", "Content-Type must be the file's media type")
+			return
+		}
+		if r.ContentLength > s.maxSize {
+			writeError(w, http.StatusRequestEntityTooLarge, "file_too_large", "files may be at most "+strconv.FormatInt(s.maxSize, 10)+" bytes")
+			return
+		}
+		body := http.MaxBytesReader(w, r.Body, s.maxSize)
+
+		f, err := s.client.Files.Upload(r.Context(), body, &genai.UploadFileConfig{
+			MIMEType:    mimeType,
+			DisplayName: r.URL.Query().Get("display_name"),
+		})
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, http.StatusRequestEntityTooLarge, "file_too_large", "files may be at most "+strconv.FormatInt(s.maxSize, 10)+" bytes")
+				return
+			}
+			slog.Error("failed to upload file", "error", err)
+			writeError(w, http.StatusBadGateway, "upstream_error", "failed to upload file")
+			return
+		}
+		slog.Info("uploaded file", "name", f.Name, "mime_type", f.MIMEType, "expires", f.ExpirationTime)
+
+		resp := fileResponse{
+			Name:     f.Name,
+			URI:      f.URI,
+			MIMEType: f.MIMEType,
+			Expires:  f.ExpirationTime,
+		}
+		if f.SizeBytes != nil {
+			resp.SizeBytes = *f.SizeBytes
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// parts looks up uploaded files by name, such as "files/abc123", and
+// returns parts referring to them.
+func (s *fileStore) parts(ctx context.Context, names []string) ([]*genai.Part, *apiError) {
+	if len(names) > maxMessageFiles {
+		return nil, &apiError{http.StatusRequestEntityTooLarge, "too_many_files", "at most " + strconv.Itoa(maxMessageFiles) + " files may be attached"}
+	}
+
+	var parts []*genai.Part
+	for _, name := range names {
+		if !strings.HasPrefix(name, "files/") {
+			name = "files/" + name
+		}
+		f, err := s.client.Files.Get(ctx, name, nil)
+		if err != nil {
+			var apiErr genai.APIError
+			if errors.As(err, &apiErr) && (apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusForbidden) {
+				return nil, &apiError{http.StatusNotFound, "file_not_found", name + " does not exist or has expired"}
+			}
+			slog.Error("failed to get file", "name", name, "error", err)
+			return nil, &apiError{http.StatusBadGateway, "upstream_error", "failed to look up " + name}
+		}
+		switch {
+		case !f.ExpirationTime.IsZero() && time.Now().After(f.ExpirationTime):
+			return nil, &apiError{http.StatusGone, "file_expired", name + " has expired"}
+		case f.State == genai.FileStateProcessing:
+			return nil, &apiError{http.StatusConflict, "file_not_ready", name + " is still being processed"}
+		case f.State == genai.FileStateFailed:
+			return nil, &apiError{http.StatusUnprocessableEntity, "file_failed", name + " could not be processed"}
+		}
+		parts = append(parts, genai.NewPartFromURI(f.URI, f.MIMEType))
+	}
+	return parts, nil
+}
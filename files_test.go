@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// fakeFilesAPI is the part of the Gemini Files API that fileStore uses:
+// resumable uploads and getting files by name.
+type fakeFilesAPI struct {
+	*httptest.Server
+	files map[string]map[string]any // by name, such as files/abc
+}
+
+func newFakeFilesAPI(t *testing.T) *fakeFilesAPI {
+	t.Helper()
+	f := &fakeFilesAPI{files: map[string]map[string]any{
+		"files/ready":      {"state": "ACTIVE"},
+		"files/expired":    {"state": "ACTIVE", "expirationTime": time.Now().Add(-time.Hour).Format(time.RFC3339)},
+		"files/processing": {"state": "PROCESSING"},
+		"files/failed":     {"state": "FAILED"},
+	}}
+	for name, file := range f.files {
+		file["name"] = name
+		file["uri"] = "https://example.com/" + name
+		file["mimeType"] = "image/png"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /upload/v1beta/files", func(w http.ResponseWriter, r *http.Request) {
+		var start struct {
+			File map[string]any `json:"file"`
+		}
+		json.NewDecoder(r.Body).Decode(&start)
+		q := "?mime_type=" + r.Header.Get("X-Goog-Upload-Header-Content-Type")
+		if name, ok := start.File["displayName"].(string); ok {
+			q += "&display_name=" + name
+		}
+		w.Header().Set("X-Goog-Upload-Url", f.URL+"/upload-session"+q)
+		w.Write([]byte("{}"))
+	})
+	mux.HandleFunc("POST /upload-session", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Goog-Upload-Status", "final")
+		json.NewEncoder(w).Encode(map[string]any{"file": map[string]any{
+			"name":        "files/new",
+			"displayName": r.URL.Query().Get("display_name"),
+			"uri":         "https://example.com/files/new",
+			"mimeType":    r.URL.Query().Get("mime_type"),
+			"sizeBytes":   strconv.Itoa(len(data)),
+		}})
+	})
+	mux.HandleFunc("GET /v1beta/files/{id}", func(w http.ResponseWriter, r *http.Request) {
+		name := "files/" + r.PathValue("id")
+		w.Header().Set("Content-Type", "application/json")
+		file, ok := f.files[name]
+		switch {
+		case name == "files/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(geminiError(http.StatusInternalServerError, "INTERNAL"))
+		case !ok:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(geminiError(http.StatusNotFound, "NOT_FOUND"))
+		default:
+			json.NewEncoder(w).Encode(file)
+		}
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// store returns a fileStore using the fake API.
+func (f *fakeFilesAPI) store(t *testing.T, maxSize int64) *fileStore {
+	t.Helper()
+	client, err := genai.NewClient(t.Context(), newClientConfig("test-key", nil, f.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fileStore{client: client, maxSize: maxSize}
+}
+
+func TestFileUpload(t *testing.T) {
+	h := newFakeFilesAPI(t).store(t, 16).uploadHandler()
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		chunked     bool // send without a Content-Length
+		status      int
+		code        string
+	}{
+		{"ok", "image/png", "12345", false, http.StatusCreated, ""},
+		{"no content type", "", "12345", false, http.StatusUnsupportedMediaType, "missing_content_type"},
+		{"too large", "image/png", strings.Repeat("x", 17), false, http.StatusRequestEntityTooLarge, "file_too_large"},
+		{"too large without length", "image/png", strings.Repeat("x", 17), true, http.StatusRequestEntityTooLarge, "file_too_large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/files?display_name=cat", strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.code != "" {
+				if code := decodeError(t, rec); code != tt.code {
+					t.Errorf("code = %q, want %q", code, tt.code)
+				}
+				return
+			}
+			var got fileResponse
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != "files/new" || got.MIMEType != "image/png" || got.SizeBytes != 5 {
+				t.Errorf("file = %+v", got)
+			}
+		})
+	}
+}
+
+func TestFileStoreParts(t *testing.T) {
+	s := newFakeFilesAPI(t).store(t, 16)
+	tests := []struct {
+		names  []string
+		parts  int
+		status int
+		code   string
+	}{
+		{[]string{"files/ready"}, 1, 0, ""},
+		{[]string{"ready", "files/ready"}, 2, 0, ""},
+		{[]string{"files/missing"}, 0, http.StatusNotFound, "file_not_found"},
+		{[]string{"files/expired"}, 0, http.StatusGone, "file_expired"},
+		{[]string{"files/processing"}, 0, http.StatusConflict, "file_not_ready"},
+		{[]string{"files/failed"}, 0, http.StatusUnprocessableEntity, "file_failed"},
+		{[]string{"files/broken"}, 0, http.StatusBadGateway, "upstream_error"},
+		{strings.Split(strings.Repeat("ready ", maxMessageFiles+1), " ")[:maxMessageFiles+1], 0, http.StatusRequestEntityTooLarge, "too_many_files"},
+	}
+	for _, tt := range tests {
+		parts, e := s.parts(t.Context(), tt.names)
+		if tt.code != "" {
+			if e == nil || e.Status != tt.status || e.Code != tt.code {
+				t.Errorf("parts(%q) error = %+v, want %d %s", tt.names, e, tt.status, tt.code)
+			}
+			continue
+		}
+		if e != nil || len(parts) != tt.parts {
+			t.Errorf("parts(%q) = %d parts, %+v; want %d", tt.names, len(parts), e, tt.parts)
+			continue
+		}
+		for _, p := range parts {
+			if p.FileData == nil || p.FileData.FileURI != "https://example.com/files/ready" || p.FileData.MIMEType != "image/png" {
+				t.Errorf("part = %+v", p)
+			}
+		}
+	}
+}
+
+// TestChatFiles attaches a file to a message.
+func TestChatFiles(t *testing.T) {
+	files := newFakeFilesAPI(t).store(t, 16)
+	llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("a cat")}}
+	cfg, _ := testConfig(t, "-dry-run")
+	sessionService := session.InMemoryService()
+	chat := chatHandler(cfg, newTestRunner(t, cfg, sessionService, llm), sessionService, nil, files, nil, nil, nil)
+
+	for _, tt := range []struct {
+		target string
+		status int
+	}{
+		{"/?node_id=n1&msg=what+is+this&file=ready", http.StatusOK},
+		{"/?node_id=n1&msg=and+this&file=missing", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		chat.ServeHTTP(rec, httptest.NewRequest("GET", tt.target, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.target, rec.Code, tt.status, rec.Body)
+		}
+	}
+	if n := llm.numCalls(); n != 1 {
+		t.Fatalf("model called %d times, want 1", n)
+	}
+	parts := llm.calls[0].Contents[0].Parts
+	if len(parts) != 2 || parts[0].FileData == nil || parts[1].Text != "what is this" {
+		t.Errorf("user turn parts = %+v, want the file then the text", parts)
+	}
+
+	// Without the Gemini API there is no file store.
+	c := newTestChat(t, echoModel{})
+	if rec := c.get(t, nil, "msg", "hi", "file", "ready"); rec.Code != http.StatusBadRequest {
+		t.Errorf("status without a file store = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// replyFormat is a representation of a reply chosen by the Accept header.
+type replyFormat int
+
+const (
+	formatRaw         replyFormat = iota // the model's text as-is, labeled text/plain
+	formatText                           // markdown stripped to plain text
+	formatMarkdown                       // the model's markdown
+	formatHTML                           // markdown rendered to HTML
+	formatJSON                           // wrapped in a JSON object
+	formatEventStream                    // streamed as server-sent events
+)
+
+var replyFormats = map[string]replyFormat{
+	"text/plain":        formatText,
+	"text/markdown":     formatMarkdown,
+	"text/html":         formatHTML,
+	"application/json":  formatJSON,
+	"text/event-stream": formatEventStream,
+}
+
+// markdown parses GitHub flavored markdown. Its HTML renderer omits raw
+// HTML and dangerous link URLs.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// negotiateFormat returns the supported format with the highest quality in
+// an Accept header. Clients that do not ask for one of the supported types,
+// including those that accept anything, get the raw reply, as before.
+func negotiateFormat(accept string) replyFormat {
+	best, bestQ := formatRaw, 0.0
+	for part := range strings.SplitSeq(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		f, ok := replyFormats[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	return best
+}
+
+// writeReply writes the reply text in the format negotiated from the
+// request's Accept header.
+func writeReply(w http.ResponseWriter, r *http.Request, txt, modelVersion string) error {
+	w.Header().Add("Vary", "Accept")
+	switch negotiateFormat(r.Header.Get("Accept")) {
+	case formatText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, err := w.Write([]byte(markdownToText(txt)))
+		return err
+	case formatMarkdown:
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		_, err := w.Write([]byte(txt))
+		return err
+	case formatHTML:
+		var buf bytes.Buffer
+		if err := markdown.Convert([]byte(txt), &buf); err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, err := w.Write(buf.Bytes())
+		return err
+	case formatJSON:
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(struct {
+			Text  string `json:"text"`
+			Model string `json:"model,omitempty"`
+		}{txt, modelVersion})
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, err := w.Write([]byte(txt))
+		return err
+	}
+}
+
+// markdownToText strips markdown formatting, keeping the text, list
+// markers, and code.
+func markdownToText(src string) string {
+	source := []byte(src)
+	doc := markdown.Parser().Parse(text.NewReader(source))
+
+	var b strings.Builder
+	depth := 0
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch n := n.(type) {
+		case *ast.Text:
+			if entering {
+				b.Write(n.Segment.Value(source))
+				if n.SoftLineBreak() || n.HardLineBreak() {
+					b.WriteByte('\n')
+				}
+			}
+		case *ast.String:
+			if entering {
+				b.Write(n.Value)
+			}
+		case *ast.AutoLink:
+			if entering {
+				b.Write(n.Label(source))
+			}
+		case *ast.RawHTML, *ast.HTMLBlock:
+			return ast.WalkSkipChildren, nil
+		case *ast.CodeBlock, *ast.FencedCodeBlock:
+			if entering {
+				lines := n.Lines()
+				for i := range lines.Len() {
+					seg := lines.At(i)
+					b.Write(seg.Value(source))
+				}
+			}
+		case *ast.List:
+			if entering {
+				depth++
+			} else {
+				depth--
+			}
+		case *ast.ListItem:
+			if entering {
+				b.WriteString(strings.Repeat("  ", depth-1))
+				if list := n.Parent().(*ast.List); list.IsOrdered() {
+					fmt.Fprintf(&b, "%d. ", list.Start+childIndex(n))
+				} else {
+					b.WriteString("- ")
+				}
+			}
+		case *east.TableCell:
+			if entering && n.PreviousSibling() != nil {
+				b.WriteString(" | ")
+			}
+		case *east.TableHeader, *east.TableRow:
+			if !entering {
+				b.WriteByte('\n')
+			}
+		case *ast.Paragraph, *ast.TextBlock, *ast.Heading:
+			if !entering {
+				b.WriteByte('\n')
+			}
+		}
+		// Separate top level blocks with a blank line.
+		if !entering && n.Type() == ast.TypeBlock && n.Parent() == doc {
+			b.WriteByte('\n')
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(b.String())
+}
+
+// childIndex returns the position of n among its siblings.
+func childIndex(n ast.Node) int {
+	i := 0
+	for p := n.PreviousSibling(); p != nil; p = p.PreviousSibling() {
+		i++
+	}
+	return i
+}
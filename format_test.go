@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   replyFormat
+	}{
+		{"", formatRaw},
+		{"*/*", formatRaw},
+		{"text/plain", formatText},
+		{"text/markdown", formatMarkdown},
+		{"text/html", formatHTML},
+		{"application/json", formatJSON},
+		{"text/event-stream", formatEventStream},
+		{"image/png, text/html", formatHTML},
+		{"text/html;q=0.5, application/json", formatJSON},
+		{"text/html;q=0.9, application/json;q=0.1", formatHTML},
+		{"text/html;q=bad, text/markdown;q=0.2", formatMarkdown},
+		{"text/html;q=0", formatRaw},
+		{"text/html; charset=utf-8", formatHTML},
+		{"nonsense;;", formatRaw},
+	}
+	for _, tt := range tests {
+		if got := negotiateFormat(tt.accept); got != tt.want {
+			t.Errorf("negotiateFormat(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestMarkdownToText(t *testing.T) {
+	tests := []struct {
+		md   string
+		want string
+	}{
+		{"Hello", "Hello"},
+		{"**Bold** and _em_ and `code`", "Bold and em and code"},
+		{"# Title\n\nBody", "Title\n\nBody"},
+		{"- one\n- two", "- one\n- two"},
+		{"3. three\n4. four", "3. three\n4. four"},
+		{"- outer\n  - inner", "- outer\n  - inner"},
+		{"[link](https://example.com)", "link"},
+		{"<https://example.com>", "https://example.com"},
+		{"```go\nx := 1\n```", "x := 1"},
+		{"a <b>raw</b> c", "a raw c"},
+		{"| a | b |\n|---|---|\n| 1 | 2 |", "a | b\n1 | 2"},
+	}
+	for _, tt := range tests {
+		if got := markdownToText(tt.md); got != tt.want {
+			t.Errorf("markdownToText(%q) = %q, want %q", tt.md, got, tt.want)
+		}
+	}
+}
+
+func TestChatAccept(t *testing.T) {
+	llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("**Hi** <script>x()</script>")}}
+	c := newTestChat(t, llm)
+	tests := []struct {
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"", "text/plain; charset=utf-8", "**Hi** <script>x()</script>"},
+		{"text/plain", "text/plain; charset=utf-8", "Hi x()"},
+		{"text/markdown", "text/markdown; charset=utf-8", "**Hi** <script>x()</script>"},
+		{"text/html", "text/html; charset=utf-8", "<p><strong>Hi</strong> <!-- raw HTML omitted -->x()<!-- raw HTML omitted --></p>\n"},
+		{"application/json", "application/json", `{"text":"**Hi** \u003cscript\u003ex()\u003c/script\u003e"}` + "\n"},
+	}
+	for _, tt := range tests {
+		rec := c.get(t, http.Header{"Accept": {tt.accept}}, "msg", "hi")
+		if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("Accept %q: Content-Type = %q, want %q", tt.accept, got, tt.contentType)
+		}
+		if got := rec.Body.String(); got != tt.body {
+			t.Errorf("Accept %q: body = %q, want %q", tt.accept, got, tt.body)
+		}
+		if rec.Header().Get("Vary") != "Accept" {
+			t.Errorf("Accept %q: Vary = %q, want Accept", tt.accept, rec.Header().Get("Vary"))
+		}
+	}
+}
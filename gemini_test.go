@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGemini is a Gemini API server. respond answers each call with a
+// status and a JSON body; by default it replies "ok".
+type fakeGemini struct {
+	*httptest.Server
+	respond func(req fakeGeminiRequest) (status int, body any)
+
+	mu       sync.Mutex
+	requests []fakeGeminiRequest
+}
+
+type fakeGeminiRequest struct {
+	Model  string
+	Method string // such as generateContent
+	Key    string
+	Stream bool
+	Body   map[string]any
+	Header http.Header
+}
+
+func newFakeGemini(t *testing.T) *fakeGemini {
+	t.Helper()
+	g := &fakeGemini{}
+	g.Server = httptest.NewServer(http.HandlerFunc(g.serve))
+	t.Cleanup(g.Close)
+	return g
+}
+
+func (g *fakeGemini) serve(w http.ResponseWriter, r *http.Request) {
+	name, method, ok := strings.Cut(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:], ":")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	req := fakeGeminiRequest{
+		Model:  name,
+		Method: method,
+		Key:    r.Header.Get("x-goog-api-key"),
+		Stream: method == "streamGenerateContent",
+		Header: r.Header.Clone(),
+	}
+	json.NewDecoder(r.Body).Decode(&req.Body)
+	g.mu.Lock()
+	g.requests = append(g.requests, req)
+	respond := g.respond
+	g.mu.Unlock()
+
+	status, body := http.StatusOK, any(geminiText("ok"))
+	if respond != nil {
+		status, body = respond(req)
+	}
+	data, _ := json.Marshal(body)
+	if status != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(data)
+		return
+	}
+	if req.Stream {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// calls returns the requests received so far.
+func (g *fakeGemini) calls() []fakeGeminiRequest {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]fakeGeminiRequest(nil), g.requests...)
+}
+
+// geminiText is a response with one candidate holding text.
+func geminiText(text string) map[string]any {
+	return map[string]any{
+		"candidates": []any{map[string]any{
+			"content":      map[string]any{"role": "model", "parts": []any{map[string]any{"text": text}}},
+			"finishReason": "STOP",
+		}},
+		"modelVersion": "fake-1",
+	}
+}
+
+// geminiError is an API error body.
+func geminiError(code int, status string) map[string]any {
+	return map[string]any{"error": map[string]any{"code": code, "message": status, "status": status}}
+}
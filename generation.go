@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// generationOverrides change the model's generation settings for one
+// request. Nil fields keep the defaults.
+type generationOverrides struct {
+	Temperature *float32
+	TopP        *float32
+	TopK        *float32
+	MaxTokens   int32
+	// Candidates asks for more than one reply. The session keeps the
+	// first; see alternativesKey.
+	Candidates int32
+}
+
+// maxCandidates is the most candidates the Gemini API will write.
+const maxCandidates = 8
+
+// parseGenerationOverrides reads the temperature, top_p, top_k,
+// max_tokens, and candidates query parameters. It returns nil if none are set.
+func parseGenerationOverrides(q url.Values) (*generationOverrides, *apiError) {
+	var o generationOverrides
+	set := false
+
+	parse := func(name string, min, max float64) (*float32, *apiError) {
+		s := q.Get(name)
+		if s == "" {
+			return nil, nil
+		}
+		f, err := strconv.ParseFloat(s, 32)
+		if err != nil || f < min || f > max {
+			return nil, &apiError{http.StatusBadRequest, "invalid_parameter", name + " must be a number from " + strconv.FormatFloat(min, 'g', -1, 64) + " to " + strconv.FormatFloat(max, 'g', -1, 64)}
+		}
+		set = true
+		v := float32(f)
+		return &v, nil
+	}
+
+	var e *apiError
+	if o.Temperature, e = parse("temperature", 0, 2); e != nil {
+		return nil, e
+	}
+	if o.TopP, e = parse("top_p", 0, 1); e != nil {
+		return nil, e
+	}
+	if o.TopK, e = parse("top_k", 1, 1000); e != nil {
+		return nil, e
+	}
+	if s := q.Get("max_tokens"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil || n < 1 {
+			return nil, &apiError{http.StatusBadRequest, "invalid_parameter", "max_tokens must be a positive integer"}
+		}
+		o.MaxTokens = int32(n)
+		set = true
+	}
+	if s := q.Get("candidates"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil || n < 1 || n > maxCandidates {
+			return nil, &apiError{http.StatusBadRequest, "invalid_parameter", "candidates must be an integer from 1 to " + strconv.Itoa(maxCandidates)}
+		}
+		o.Candidates = int32(n)
+		set = true
+	}
+
+	if !set {
+		return nil, nil
+	}
+	return &o, nil
+}
+
+type generationKey struct{}
+
+// withGenerationOverrides returns a context carrying o for the model calls
+// made while handling a request.
+func withGenerationOverrides(ctx context.Context, o *generationOverrides) context.Context {
+	return context.WithValue(ctx, generationKey{}, o)
+}
+
+// generationCallback returns a callback that applies the overrides carried
+// by the request's context, if any. The request's config is copied, so the
+// agent's defaults are not changed.
+func generationCallback() llmagent.BeforeModelCallback {
+	return func(ctx agent.CallbackContext, req *model.LLMRequest) (*model.LLMResponse, error) {
+		o, ok := ctx.Value(generationKey{}).(*generationOverrides)
+		if !ok || o == nil {
+			return nil, nil
+		}
+		var cfg genai.GenerateContentConfig
+		if req.Config != nil {
+			cfg = *req.Config
+		}
+		if o.Temperature != nil {
+			cfg.Temperature = o.Temperature
+		}
+		if o.TopP != nil {
+			cfg.TopP = o.TopP
+		}
+		if o.TopK != nil {
+			cfg.TopK = o.TopK
+		}
+		if o.MaxTokens > 0 {
+			cfg.MaxOutputTokens = o.MaxTokens
+		}
+		if o.Candidates > 0 {
+			cfg.CandidateCount = o.Candidates
+		}
+		req.Config = &cfg
+		return nil, nil
+	}
+}
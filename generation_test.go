@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseGenerationOverrides(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    *generationOverrides
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"msg=hi", nil, false},
+		{"temperature=0.5", &generationOverrides{Temperature: ptr(float32(0.5))}, false},
+		{"temperature=0&top_p=1&top_k=40", &generationOverrides{Temperature: ptr(float32(0)), TopP: ptr(float32(1)), TopK: ptr(float32(40))}, false},
+		{"max_tokens=100&candidates=3", &generationOverrides{MaxTokens: 100, Candidates: 3}, false},
+		{"temperature=2.1", nil, true},
+		{"temperature=-1", nil, true},
+		{"temperature=hot", nil, true},
+		{"top_p=1.5", nil, true},
+		{"top_k=0", nil, true},
+		{"max_tokens=0", nil, true},
+		{"max_tokens=1.5", nil, true},
+		{"candidates=9", nil, true},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		got, e := parseGenerationOverrides(q)
+		if tt.wantErr {
+			if e == nil || e.Status != http.StatusBadRequest || e.Code != "invalid_parameter" {
+				t.Errorf("%q: error = %+v, want invalid_parameter", tt.query, e)
+			}
+			continue
+		}
+		if e != nil {
+			t.Errorf("%q: error = %+v", tt.query, e)
+			continue
+		}
+		if (got == nil) != (tt.want == nil) || (got != nil && !equalOverrides(got, tt.want)) {
+			t.Errorf("%q: overrides = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func equalOverrides(a, b *generationOverrides) bool {
+	eq := func(x, y *float32) bool { return (x == nil) == (y == nil) && (x == nil || *x == *y) }
+	return eq(a.Temperature, b.Temperature) && eq(a.TopP, b.TopP) && eq(a.TopK, b.TopK) &&
+		a.MaxTokens == b.MaxTokens && a.Candidates == b.Candidates
+}
+
+// TestChatGenerationOverrides checks that overrides apply to one message
+// only.
+func TestChatGenerationOverrides(t *testing.T) {
+	llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("ok")}}
+	c := newTestChat(t, llm)
+	for _, params := range [][]string{
+		{"msg", "one", "node_id", "n1", "temperature", "1.5", "max_tokens", "50"},
+		{"msg", "two", "node_id", "n1"},
+	} {
+		if rec := c.get(t, nil, params...); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+	}
+	if rec := c.get(t, nil, "msg", "three", "node_id", "n1", "top_p", "2"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid top_p: status = %d, want 400", rec.Code)
+	}
+
+	first, second := llm.calls[0].Config, llm.calls[1].Config
+	if first.Temperature == nil || *first.Temperature != 1.5 || first.MaxOutputTokens != 50 {
+		t.Errorf("first message config: temperature %v, max tokens %d; want 1.5 and 50", first.Temperature, first.MaxOutputTokens)
+	}
+	if second.Temperature != nil || second.MaxOutputTokens != 0 {
+		t.Errorf("second message config: temperature %v, max tokens %d; want the defaults", second.Temperature, second.MaxOutputTokens)
+	}
+	if n := llm.numCalls(); n != 2 {
+		t.Errorf("model called %d times, want 2", n)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// rejectWhenDraining makes next respond 503, through fail, while draining
+// is set. Requests already in progress when draining starts are allowed to
+// finish.
+func rejectWhenDraining(draining *atomic.Bool, fail errorFunc, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if draining.Load() {
+			w.Header().Set("Retry-After", "30")
+			fail(w, http.StatusServiceUnavailable, "draining", "the server is not accepting new requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// healthzHandler reports that the process is alive, along with whether it
+// is draining.
+func healthzHandler(draining *atomic.Bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if draining.Load() {
+			w.Write([]byte("ok (draining)\n"))
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// readyzHandler fails while draining so load balancers stop sending
+// traffic to this instance.
+func readyzHandler(draining *atomic.Bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if draining.Load() {
+			writeError(w, http.StatusServiceUnavailable, "draining", "the server is draining")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ready\n"))
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDrainAndResume(t *testing.T) {
+	c, mux, draining := newTestAdmin(t)
+	mux.Handle("GET /{$}", rejectWhenDraining(draining, writeError, c))
+	mux.HandleFunc("GET /healthz", healthzHandler(draining))
+	mux.HandleFunc("GET /readyz", readyzHandler(draining))
+
+	type check struct {
+		path   string
+		status int
+		body   string // prefix of the body, when not empty
+	}
+	steps := []struct {
+		action string // admin path to post first, if any
+		checks []check
+	}{
+		{"", []check{
+			{"/?msg=hi", http.StatusOK, "[dry-run] hi"},
+			{"/healthz", http.StatusOK, "ok\n"},
+			{"/readyz", http.StatusOK, "ready\n"},
+		}},
+		{"/admin/drain", []check{
+			{"/?msg=hi", http.StatusServiceUnavailable, ""},
+			{"/healthz", http.StatusOK, "ok (draining)\n"},
+			{"/readyz", http.StatusServiceUnavailable, `{"error":{"code":"draining"`},
+		}},
+		{"/admin/resume", []check{
+			{"/?msg=hi", http.StatusOK, "[dry-run] hi"},
+			{"/healthz", http.StatusOK, "ok\n"},
+			{"/readyz", http.StatusOK, "ready\n"},
+		}},
+	}
+	for _, step := range steps {
+		if step.action != "" {
+			if rec := adminRequest(mux, http.MethodPost, step.action, testAdminToken, nil); rec.Code != http.StatusNoContent {
+				t.Fatalf("%s: status = %d, want 204", step.action, rec.Code)
+			}
+		}
+		for _, ck := range step.checks {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("GET", ck.path, nil))
+			if rec.Code != ck.status {
+				t.Errorf("after %q: GET %s status = %d, want %d", step.action, ck.path, rec.Code, ck.status)
+			}
+			if ck.body != "" && !strings.HasPrefix(rec.Body.String(), ck.body) {
+				t.Errorf("after %q: GET %s body = %q, want prefix %q", step.action, ck.path, rec.Body, ck.body)
+			}
+		}
+	}
+}
+
+func TestDrainRejection(t *testing.T) {
+	var draining atomic.Bool
+	draining.Store(true)
+	h := rejectWhenDraining(&draining, writeError, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called while draining")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/?msg=hi", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("got %d with Retry-After %q, want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if code := decodeError(t, rec); code != "draining" {
+		t.Errorf("error code = %q, want draining", code)
+	}
+}
+
+// TestDrainLetsRequestsFinish starts draining while a request is in
+// progress.
+func TestDrainLetsRequestsFinish(t *testing.T) {
+	var draining atomic.Bool
+	started, release := make(chan struct{}), make(chan struct{})
+	h := rejectWhenDraining(&draining, writeError, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	}))
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/?msg=hi", nil))
+		close(done)
+	}()
+	<-started
+	draining.Store(true)
+	close(release)
+	<-done
+	if rec.Code != http.StatusOK || rec.Body.String() != "done" {
+		t.Errorf("got %d %q, want 200 done", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// historyCompactor shortens the history sent to the model once it grows
+// past maxBytes, dropping the oldest whole turns until keepBytes remain.
+// With a model to summarize, the dropped turns are replaced by a summary
+// the model writes. The point at which history was cut is kept per
+// session, so it stays put until the history crosses the limit again. The
+// session itself keeps the full history.
+type historyCompactor struct {
+	llm       model.LLM // nil to truncate
+	maxBytes  int
+	keepBytes int
+
+	mu        sync.Mutex
+	summaries map[string]historySummary
+}
+
+// historySummary covers the first upto contents of a session's history.
+// The text is empty when truncating.
+type historySummary struct {
+	upto int
+	text string
+}
+
+// newHistoryCompactor returns a compactor that keeps keepFraction of
+// maxBytes when it cuts. If llm is not nil, it is asked to summarize what
+// is cut.
+func newHistoryCompactor(llm model.LLM, maxBytes int, keepFraction float64) *historyCompactor {
+	return &historyCompactor{
+		llm:       llm,
+		maxBytes:  maxBytes,
+		keepBytes: int(float64(maxBytes) * keepFraction),
+		summaries: make(map[string]historySummary),
+	}
+}
+
+// callback returns a BeforeModelCallback that applies the compaction. If
+// the model cannot summarize, the turns are dropped instead.
+func (h *historyCompactor) callback() llmagent.BeforeModelCallback {
+	return func(ctx agent.CallbackContext, req *model.LLMRequest) (*model.LLMResponse, error) {
+		sessionID := ctx.SessionID()
+
+		h.mu.Lock()
+		sum, ok := h.summaries[sessionID]
+		h.mu.Unlock()
+		if !ok || sum.upto > len(req.Contents) {
+			// Not cut yet, or the session was deleted and started over.
+			sum = historySummary{}
+		}
+
+		rest := req.Contents[sum.upto:]
+		if size := len(sum.text) + historySize(rest); size > h.maxBytes {
+			if cut := historyCut(rest, h.keepBytes-len(sum.text)); cut > 0 {
+				text := sum.text
+				if h.llm != nil {
+					var err error
+					if text, err = h.summarize(ctx, sum.text, rest[:cut]); err != nil {
+						slog.Warn("failed to summarize history, dropping oldest turns", "session_id", sessionID, "error", err)
+						text = sum.text
+					}
+				}
+				sum = historySummary{upto: sum.upto + cut, text: text}
+				rest = rest[cut:]
+				slog.Debug("compacted history", "session_id", sessionID, "dropped", sum.upto, "summary", len(text))
+
+				h.mu.Lock()
+				h.summaries[sessionID] = sum
+				h.mu.Unlock()
+			}
+		}
+
+		req.Contents = rest
+		if sum.text != "" {
+			summary := genai.NewContentFromText("[summary of the earlier conversation]\n"+sum.text, genai.RoleUser)
+			req.Contents = append([]*genai.Content{summary}, rest...)
+		}
+		return nil, nil
+	}
+}
+
+// forget drops the compaction kept for a session, once it is deleted.
+func (h *historyCompactor) forget(sessionID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.summaries, sessionID)
+}
+
+// historyCut returns how many contents to drop from the start so that the
+// rest is at most keepBytes. Only whole turns are dropped. A turn starts
+// with a user message and includes the model's replies and any tool calls
+// that follow it. The latest turn is always kept.
+func historyCut(contents []*genai.Content, keepBytes int) int {
+	var starts []int
+	total := 0
+	for i, c := range contents {
+		if isTurnStart(c) {
+			starts = append(starts, i)
+		}
+		total += contentSize(c)
+	}
+
+	if len(starts) < 2 {
+		return 0
+	}
+
+	cut := 0
+	for _, start := range starts[1:] {
+		if total <= keepBytes {
+			break
+		}
+		for _, c := range contents[cut:start] {
+			total -= contentSize(c)
+		}
+		cut = start
+	}
+	return cut
+}
+
+// historySize approximates the size of contents as sent to the model.
+func historySize(contents []*genai.Content) int {
+	n := 0
+	for _, c := range contents {
+		n += contentSize(c)
+	}
+	return n
+}
+
+// isTurnStart reports whether c is a message typed by the user, as opposed
+// to a tool result, which is also sent with the user role.
+func isTurnStart(c *genai.Content) bool {
+	if c == nil || c.Role != genai.RoleUser {
+		return false
+	}
+	for _, p := range c.Parts {
+		if p.FunctionResponse != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// contentSize approximates the size of c as sent to the model.
+func contentSize(c *genai.Content) int {
+	if c == nil {
+		return 0
+	}
+	n := 0
+	for _, p := range c.Parts {
+		n += len(p.Text)
+		if p.FunctionCall != nil {
+			b, _ := json.Marshal(p.FunctionCall)
+			n += len(b)
+		}
+		if p.FunctionResponse != nil {
+			b, _ := json.Marshal(p.FunctionResponse)
+			n += len(b)
+		}
+	}
+	return n
+}
+
+// summaryPrompt asks the model to compact the older part of a conversation.
+const summaryPrompt = `Summarize the conversation below between a user and an assistant so that
+the assistant can continue it. Keep names, facts, preferences, and open
+questions. Reply with the summary only.
+
+`
+
+// summarize asks the model for a summary of an earlier summary followed by
+// contents. It is a one-off request, so nothing is added to the session.
+func (h *historyCompactor) summarize(ctx context.Context, previous string, contents []*genai.Content) (string, error) {
+	var b strings.Builder
+	b.WriteString(summaryPrompt)
+	if previous != "" {
+		b.WriteString("Summary of the conversation so far:\n")
+		b.WriteString(previous)
+		b.WriteString("\n\n")
+	}
+	for _, c := range contents {
+		if !isTurnStart(c) && c.Role != genai.RoleModel {
+			continue
+		}
+		var txt string
+		for _, p := range c.Parts {
+			if p != nil && !p.Thought {
+				txt += p.Text
+			}
+		}
+		if txt == "" {
+			continue
+		}
+		if c.Role == genai.RoleModel {
+			b.WriteString("Assistant: ")
+		} else {
+			b.WriteString("User: ")
+		}
+		b.WriteString(txt)
+		b.WriteString("\n")
+	}
+
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText(b.String(), genai.RoleUser)},
+		Config:   &genai.GenerateContentConfig{},
+	}
+	var rep reply
+	for resp, err := range h.llm.GenerateContent(ctx, req, false) {
+		if err != nil {
+			return "", err
+		}
+		if resp.Content != nil {
+			for _, p := range resp.Content.Parts {
+				if p != nil && !p.Thought {
+					rep.Text += p.Text
+				}
+			}
+		}
+		if resp.UsageMetadata != nil {
+			rep.PromptTokens += int64(resp.UsageMetadata.PromptTokenCount)
+			rep.ResponseTokens += int64(resp.UsageMetadata.CandidatesTokenCount)
+		}
+	}
+	recordUsage(ctx, rep)
+
+	text := strings.TrimSpace(rep.Text)
+	if text == "" {
+		return "", errors.New("empty summary")
+	}
+	return text, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+func TestHistoryCut(t *testing.T) {
+	user := func(text string) *genai.Content { return genai.NewContentFromText(text, genai.RoleUser) }
+	reply := func(text string) *genai.Content { return genai.NewContentFromText(text, genai.RoleModel) }
+	tool := &genai.Content{Role: genai.RoleUser, Parts: []*genai.Part{genai.NewPartFromFunctionResponse("f", nil)}}
+
+	tests := []struct {
+		name      string
+		contents  []*genai.Content
+		keepBytes int
+		want      int
+	}{
+		{"empty", nil, 0, 0},
+		{"one turn", []*genai.Content{user("aaaa"), reply("bbbb")}, 0, 0},
+		{"fits", []*genai.Content{user("aa"), reply("bb"), user("cc"), reply("dd")}, 8, 0},
+		{"drop first turn", []*genai.Content{user("aa"), reply("bb"), user("cc"), reply("dd")}, 4, 2},
+		{"keep latest turn", []*genai.Content{user("aa"), reply("bb"), user("cc"), reply("dd")}, 0, 2},
+		{"drop two turns", []*genai.Content{user("aa"), reply("bb"), user("cc"), reply("dd"), user("ee")}, 2, 4},
+		{"tool result is not a turn", []*genai.Content{user("aa"), tool, reply("bb"), user("cc")}, 2, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := historyCut(tt.contents, tt.keepBytes); got != tt.want {
+				t.Errorf("historyCut() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForgettingServiceClearsSummaries(t *testing.T) {
+	ctx := context.Background()
+	h := newHistoryCompactor(nil, 100, 0.5)
+	svc := &forgettingService{Service: session.InMemoryService(), forget: h.forget}
+
+	for _, id := range []string{"kept", "deleted"} {
+		if _, err := svc.Create(ctx, &session.CreateRequest{AppName: appName, UserID: id, SessionID: id}); err != nil {
+			t.Fatal(err)
+		}
+		h.summaries[id] = historySummary{upto: 2}
+	}
+	if err := svc.Delete(ctx, &session.DeleteRequest{AppName: appName, UserID: "deleted", SessionID: "deleted"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.summaries["deleted"]; ok {
+		t.Error("summary of deleted session was kept")
+	}
+	if _, ok := h.summaries["kept"]; !ok {
+		t.Error("summary of other session was dropped")
+	}
+}
+
+// newCompactingChat returns a chat handler answered by llm whose history is
+// shortened by h.
+func newCompactingChat(t *testing.T, llm model.LLM, h *historyCompactor) http.Handler {
+	t.Helper()
+	cfg, _ := testConfig(t, "-dry-run")
+	sessionService := session.InMemoryService()
+	run := newTestRunner(t, cfg, sessionService, llm, h.callback())
+	return chatHandler(cfg, run, sessionService, nil, nil, nil, nil, nil)
+}
+
+// TestChatHistoryMaxBytes sends oversized turns and checks that only the
+// latest ones that fit are sent to the model.
+func TestChatHistoryMaxBytes(t *testing.T) {
+	llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("ok")}}
+	chat := newCompactingChat(t, llm, newHistoryCompactor(nil, 250, 1))
+	msgs := []string{strings.Repeat("a", 100), strings.Repeat("b", 100), "short", strings.Repeat("c", 200)}
+	tests := []struct {
+		first string // the first message sent to the model
+		n     int    // how many contents are sent
+	}{
+		{msgs[0], 1},
+		{msgs[0], 3},
+		{msgs[0], 5}, // 209 bytes
+		{msgs[2], 3}, // 411 bytes, less the first two turns
+	}
+	for i, msg := range msgs {
+		rec := httptest.NewRecorder()
+		chat.ServeHTTP(rec, httptest.NewRequest("GET", "/?node_id=n1&msg="+msg, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("message %d: status = %d: %s", i+1, rec.Code, rec.Body)
+		}
+		call := llm.calls[i]
+		if got := call.Contents[0].Parts[0].Text; got != tests[i].first || len(call.Contents) != tests[i].n {
+			t.Errorf("message %d: sent %d contents starting %.10q, want %d starting %.10q", i+1, len(call.Contents), got, tests[i].n, tests[i].first)
+		}
+		if lastUserText(call.Contents) != msg {
+			t.Errorf("message %d: latest turn not sent", i+1)
+		}
+		if systemText(call) == "" {
+			t.Errorf("message %d: system instruction dropped", i+1)
+		}
+	}
+}
+
+func TestChatHistorySummarize(t *testing.T) {
+	msgs := []string{strings.Repeat("a", 100), strings.Repeat("b", 100), "short", strings.Repeat("c", 200), "more"}
+	tests := []struct {
+		name      string
+		summary   fakeReply
+		wantFirst string // the first content sent with the last two messages
+	}{
+		{"summarized", textReply(" the user sent a and b "), "[summary of the earlier conversation]\nthe user sent a and b"},
+		{"summarizer fails", fakeReply{err: errors.New("boom")}, "short"},
+		{"empty summary", textReply(""), "short"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("ok")}}
+			summarizer := &fakeModel{name: "summarizer", replies: []fakeReply{tt.summary}}
+			chat := newCompactingChat(t, llm, newHistoryCompactor(summarizer, 250, 1))
+			for i, msg := range msgs {
+				rec := httptest.NewRecorder()
+				chat.ServeHTTP(rec, httptest.NewRequest("GET", "/?node_id=n1&msg="+msg, nil))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("message %d: status = %d: %s", i+1, rec.Code, rec.Body)
+				}
+			}
+			if n := summarizer.numCalls(); n != 1 {
+				t.Fatalf("summarizer called %d times, want 1", n)
+			}
+			prompt := lastUserText(summarizer.calls[0].Contents)
+			if !strings.Contains(prompt, "User: "+msgs[0]+"\nAssistant: ok\nUser: "+msgs[1]+"\n") || strings.Contains(prompt, "short") {
+				t.Errorf("summary prompt = %q, want the first two turns only", prompt)
+			}
+			for _, call := range llm.calls[3:] {
+				if got := call.Contents[0].Parts[0].Text; got != tt.wantFirst {
+					t.Errorf("first content = %.60q, want %.60q", got, tt.wantFirst)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyCache remembers responses by Idempotency-Key so that a client
+// retrying a request does not send the same message to the model twice.
+type idempotencyCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*idempotentEntry
+}
+
+type idempotentEntry struct {
+	done    chan struct{} // closed once the response is recorded
+	expires time.Time
+	ok      bool
+	status  int
+	header  http.Header
+	body    []byte
+}
+
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:     ttl,
+		entries: make(map[string]*idempotentEntry),
+	}
+}
+
+// recordingWriter passes writes through while keeping a copy of the
+// response.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int // zero until a response is written
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// middleware replays the stored response for a repeated Idempotency-Key.
+// Keys are scoped to the request's session. Only successful responses are
+// kept; a request that failed, or that wrote nothing because the client went
+// away, may be retried with the same key.
+func (c *idempotencyCache) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sessionID, err := requestSessionID(r)
+		if err != nil {
+			// The handler rejects the request.
+			next.ServeHTTP(w, r)
+			return
+		}
+		key = sessionID + "\x00" + key
+
+		for {
+			c.mu.Lock()
+			now := time.Now()
+			for k, e := range c.entries {
+				if !e.expires.IsZero() && now.After(e.expires) {
+					delete(c.entries, k)
+				}
+			}
+			e, found := c.entries[key]
+			if !found {
+				e = &idempotentEntry{done: make(chan struct{})}
+				c.entries[key] = e
+			}
+			c.mu.Unlock()
+
+			if found {
+				// Wait for the first request with this key to finish.
+				select {
+				case <-e.done:
+				case <-r.Context().Done():
+					return
+				}
+				if !e.ok {
+					continue
+				}
+				for k, v := range e.header {
+					w.Header()[k] = v
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(e.status)
+				w.Write(e.body)
+				return
+			}
+
+			rec := &recordingWriter{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+
+			c.mu.Lock()
+			if rec.status >= 200 && rec.status < 300 && r.Context().Err() == nil {
+				e.ok = true
+				e.status = rec.status
+				e.header = w.Header().Clone()
+				// A replay must not hand out the first caller's cookie.
+				e.header.Del("Set-Cookie")
+				e.body = rec.body.Bytes()
+				e.expires = time.Now().Add(c.ttl)
+			} else {
+				delete(c.entries, key)
+			}
+			c.mu.Unlock()
+			close(e.done)
+			return
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingHandler replies with the number of times it has been called and
+// the status it is told to use.
+type countingHandler struct {
+	calls  atomic.Int32
+	status atomic.Int32
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := h.calls.Add(1)
+	http.SetCookie(w, &http.Cookie{Name: "sid", Value: strconv.Itoa(int(n))})
+	status := int(h.status.Load())
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(strconv.Itoa(int(n))))
+}
+
+func idempotentRequest(h http.Handler, nodeID, key string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/?msg=hi&node_id="+nodeID, nil)
+	if key != "" {
+		r.Header.Set("Idempotency-Key", key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestIdempotencyCache(t *testing.T) {
+	type call struct {
+		nodeID   string
+		key      string
+		status   int // the handler's status
+		want     string
+		replayed bool
+	}
+	tests := []struct {
+		name  string
+		calls []call
+	}{
+		{"replays repeated key", []call{
+			{"a", "k1", 200, "1", false},
+			{"a", "k1", 200, "1", true},
+		}},
+		{"different keys", []call{
+			{"a", "k1", 200, "1", false},
+			{"a", "k2", 200, "2", false},
+		}},
+		{"no key", []call{
+			{"a", "", 200, "1", false},
+			{"a", "", 200, "2", false},
+		}},
+		{"scoped to session", []call{
+			{"a", "k1", 200, "1", false},
+			{"b", "k1", 200, "2", false},
+			{"a", "k1", 200, "1", true},
+		}},
+		{"failure not kept", []call{
+			{"a", "k1", 502, "1", false},
+			{"a", "k1", 200, "2", false},
+			{"a", "k1", 200, "2", true},
+		}},
+		{"client error not kept", []call{
+			{"a", "k1", 429, "1", false},
+			{"a", "k1", 200, "2", false},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &countingHandler{}
+			mw := newIdempotencyCache(time.Minute).middleware(h)
+			for i, c := range tt.calls {
+				h.status.Store(int32(c.status))
+				rec := idempotentRequest(mw, c.nodeID, c.key)
+				if got := rec.Body.String(); got != c.want {
+					t.Errorf("call %d: body = %q, want %q", i+1, got, c.want)
+				}
+				if got := rec.Header().Get("Idempotent-Replayed") == "true"; got != c.replayed {
+					t.Errorf("call %d: replayed = %v, want %v", i+1, got, c.replayed)
+				}
+				if c.replayed && rec.Header().Get("Set-Cookie") != "" {
+					t.Errorf("call %d: replay set cookie %q", i+1, rec.Header().Get("Set-Cookie"))
+				}
+			}
+		})
+	}
+}
+
+func TestIdempotencyCacheExpires(t *testing.T) {
+	h := &countingHandler{}
+	mw := newIdempotencyCache(time.Millisecond).middleware(h)
+	idempotentRequest(mw, "a", "k1")
+	time.Sleep(5 * time.Millisecond)
+	if got := idempotentRequest(mw, "a", "k1").Body.String(); got != "2" {
+		t.Errorf("body after expiry = %q, want 2", got)
+	}
+}
+
+func TestIdempotencyCacheConcurrent(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	mw := newIdempotencyCache(time.Minute).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Write([]byte("done"))
+	}))
+	var wg sync.WaitGroup
+	recs := make([]*httptest.ResponseRecorder, 5)
+	for i := range recs {
+		wg.Go(func() { recs[i] = idempotentRequest(mw, "a", "k1") })
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler called %d times, want 1", n)
+	}
+	for i, rec := range recs {
+		if rec.Body.String() != "done" {
+			t.Errorf("request %d: body = %q, want done", i, rec.Body)
+		}
+	}
+}
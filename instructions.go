@@ -0,0 +1,154 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// defaultSystem is the system instruction used when neither the -system
+// file nor SYSTEM_INSTRUCTION provides one.
+//
+//go:embed system.txt
+var defaultSystem string
+
+// systemInstructions holds the rendered system instructions: the default
+// and any per-language replacements.
+type systemInstructions struct {
+	Default string
+	ByLang  map[string]string
+}
+
+// forLanguage returns the instruction for lang, or the default.
+func (si *systemInstructions) forLanguage(lang string) string {
+	if s, ok := si.ByLang[lang]; ok {
+		return s
+	}
+	return si.Default
+}
+
+// loadSystemInstructions reads the system instruction files and renders
+// each one as a text/template with vars and the built-in .Now.
+func loadSystemInstructions(system string, langSystem, vars map[string]string) (*systemInstructions, error) {
+	data := make(map[string]any, len(vars)+1)
+	for k, v := range vars {
+		data[k] = v
+	}
+	data["Now"] = time.Now()
+
+	render := func(path, what string) (string, error) {
+		text, err := loadInstruction(path, what)
+		if err != nil {
+			return "", err
+		}
+		return renderInstruction(path, text, data)
+	}
+
+	si := &systemInstructions{ByLang: make(map[string]string, len(langSystem))}
+	var err error
+	if si.Default, err = loadSystemInstruction(system); err != nil {
+		return nil, err
+	}
+	if si.Default, err = renderInstruction(system, si.Default, data); err != nil {
+		return nil, err
+	}
+	for lang, path := range langSystem {
+		if si.ByLang[lang], err = render(path, lang+" system"); err != nil {
+			return nil, err
+		}
+	}
+	return si, nil
+}
+
+// loadSystemInstruction returns the default system instruction from the
+// first source available: the file at path, the SYSTEM_INSTRUCTION
+// environment variable, or the built-in defaultSystem.
+func loadSystemInstruction(path string) (string, error) {
+	if path != "" {
+		_, err := os.Stat(path)
+		if err == nil {
+			return loadInstruction(path, "system")
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	if text := os.Getenv("SYSTEM_INSTRUCTION"); text != "" {
+		slog.Info("loaded system instructions from SYSTEM_INSTRUCTION", "missing_path", path)
+		return strings.TrimRight(text, "\r\n"), nil
+	}
+	slog.Info("using built-in system instructions", "missing_path", path)
+	return strings.TrimRight(defaultSystem, "\r\n"), nil
+}
+
+// renderInstruction executes text as a template. Referring to a variable
+// that was not provided is an error.
+func renderInstruction(name, text string, data map[string]any) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parse %s: %w", name, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("render %s: %w", name, err)
+	}
+	return b.String(), nil
+}
+
+// splitInstructionCallback returns a callback that sends the system
+// instruction as separate parts, split at lines that are only delim. This
+// lets one file combine, say, a base persona with addenda for an
+// environment.
+func splitInstructionCallback(delim string) llmagent.BeforeModelCallback {
+	return func(ctx agent.CallbackContext, req *model.LLMRequest) (*model.LLMResponse, error) {
+		if req.Config == nil || req.Config.SystemInstruction == nil {
+			return nil, nil
+		}
+		si := *req.Config.SystemInstruction
+		si.Parts = nil
+		for _, p := range req.Config.SystemInstruction.Parts {
+			if p == nil || p.Text == "" {
+				si.Parts = append(si.Parts, p)
+				continue
+			}
+			for _, text := range splitInstruction(p.Text, delim) {
+				si.Parts = append(si.Parts, genai.NewPartFromText(text))
+			}
+		}
+		cfg := *req.Config
+		cfg.SystemInstruction = &si
+		req.Config = &cfg
+		return nil, nil
+	}
+}
+
+// splitInstruction splits text at lines that are only delim, dropping
+// sections that are blank.
+func splitInstruction(text, delim string) []string {
+	var sections []string
+	var b strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(b.String()); s != "" {
+			sections = append(sections, s)
+		}
+		b.Reset()
+	}
+	for line := range strings.Lines(text) {
+		if strings.TrimSpace(line) == delim {
+			flush()
+			continue
+		}
+		b.WriteString(line)
+	}
+	flush()
+	return sections
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/session"
+)
+
+// writeFile writes text to name in a temporary directory and returns its
+// path.
+func writeFile(t *testing.T, name, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadInstruction(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr string
+	}{
+		{"missing", filepath.Join(dir, "missing.txt"), "", ""},
+		{"directory", dir, "", "is not a regular file"},
+		{"trailing newline", writeFile(t, "nl.txt", "Be brief.\n"), "Be brief.", ""},
+		{"trailing CRLF lines", writeFile(t, "crlf.txt", "Be brief.\r\n\r\n"), "Be brief.", ""},
+		{"inner newlines kept", writeFile(t, "inner.txt", "One.\n\nTwo.\n"), "One.\n\nTwo.", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadInstruction(tt.path, "test")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), tt.path) {
+					t.Errorf("err = %v, want one naming %s and containing %q", err, tt.path, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("loadInstruction() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderInstruction(t *testing.T) {
+	data := map[string]any{"Name": "Chatty", "Tone": "dry", "Now": time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr string
+	}{
+		{"plain", "Be brief.", "Be brief.", ""},
+		{"variables", "You are {{.Name}}. Be {{.Tone}}.", "You are Chatty. Be dry.", ""},
+		{"now", `Today is {{.Now.Format "2006-01-02"}}.`, "Today is 2026-03-01.", ""},
+		{"missing variable", "You are {{.Nickname}}.", "", "render"},
+		{"parse error", "You are {{.Name}.", "", "parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderInstruction("system.txt", tt.text, data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), "system.txt") {
+					t.Errorf("err = %v, want a %s error naming system.txt", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("renderInstruction() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSystemInstructions(t *testing.T) {
+	system := writeFile(t, "system.txt", "You are {{.Name}}.\n")
+	es := writeFile(t, "es.txt", "Eres {{.Name}}.\n")
+	bad := writeFile(t, "bad.txt", "You are {{.Nickname}}.\n")
+
+	si, err := loadSystemInstructions(system, map[string]string{"es": es}, map[string]string{"Name": "Chatty"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if si.forLanguage("") != "You are Chatty." || si.forLanguage("fr") != "You are Chatty." || si.forLanguage("es") != "Eres Chatty." {
+		t.Errorf("instructions = %+v", si)
+	}
+
+	if _, err := loadSystemInstructions(bad, nil, map[string]string{"Name": "Chatty"}); err == nil {
+		t.Error("loadSystemInstructions accepted a missing variable in the default")
+	}
+	if _, err := loadSystemInstructions(system, map[string]string{"es": bad}, map[string]string{"Name": "Chatty"}); err == nil {
+		t.Error("loadSystemInstructions accepted a missing variable in a language file")
+	}
+}
+
+func TestLoadSystemInstructionFallback(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, "system.txt", "From the file.\n")
+	builtIn := strings.TrimRight(defaultSystem, "\r\n")
+	tests := []struct {
+		name    string
+		path    string
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{"file", file, "From the environment.", "From the file.", false},
+		{"file missing, env set", filepath.Join(dir, "missing.txt"), "From the environment.\n", "From the environment.", false},
+		{"no path, env set", "", "From the environment.", "From the environment.", false},
+		{"both missing", filepath.Join(dir, "missing.txt"), "", builtIn, false},
+		{"unreadable path", dir, "From the environment.", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SYSTEM_INSTRUCTION", tt.env)
+			got, err := loadSystemInstruction(tt.path)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("loadSystemInstruction() = %.40q, %v; want %.40q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+	if builtIn == "" {
+		t.Error("the built-in system instruction is empty")
+	}
+}
+
+func TestSplitInstruction(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		delim string
+		want  []string
+	}{
+		{"no delimiter", "You are helpful.", "---", []string{"You are helpful."}},
+		{"two parts", "You are helpful.\n---\nAnswer in one line.\n", "---", []string{"You are helpful.", "Answer in one line."}},
+		{"three parts", "a\n---\nb\n---\nc", "---", []string{"a", "b", "c"}},
+		{"padded delimiter", "a\n  ---  \nb", "---", []string{"a", "b"}},
+		{"delimiter inside a line", "a --- b\n---\nc", "---", []string{"a --- b", "c"}},
+		{"blank sections dropped", "---\na\n---\n\n---\nb\n---\n", "---", []string{"a", "b"}},
+		{"multi-line section", "a\nb\n===\nc", "===", []string{"a\nb", "c"}},
+		{"only delimiters", "---\n---", "---", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitInstruction(tt.text, tt.delim)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("splitInstruction(%q, %q) = %q, want %q", tt.text, tt.delim, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestChatSystemDelimiter checks that a delimited system instruction
+// reaches the model as separate parts.
+func TestChatSystemDelimiter(t *testing.T) {
+	t.Setenv("SYSTEM_INSTRUCTION", "You are a pirate.\n---\nKeep replies short.")
+	llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("ok")}}
+	cfg, _ := testConfig(t, "-dry-run", "-system-delimiter", "---")
+	sessionService := session.InMemoryService()
+	run := newTestRunner(t, cfg, sessionService, llm, splitInstructionCallback(cfg.SystemDelimiter))
+	chat := chatHandler(cfg, run, sessionService, nil, nil, nil, nil, nil)
+	rec := httptest.NewRecorder()
+	chat.ServeHTTP(rec, httptest.NewRequest("GET", "/?node_id=n1&msg=hi", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var texts []string
+	for _, p := range llm.calls[0].Config.SystemInstruction.Parts {
+		texts = append(texts, p.Text)
+	}
+	// The agent appends its own text to the last section.
+	if len(texts) != 2 || texts[0] != "You are a pirate." || !strings.HasPrefix(texts[1], "Keep replies short.\n") {
+		t.Errorf("system instruction parts = %.60q, want two sections", texts)
+	}
+}
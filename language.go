@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// languageKey is the session state key holding the detected language of a
+// session's first message.
+const languageKey = "language"
+
+// scriptLanguages maps writing systems used by a single language to that
+// language's code.
+var scriptLanguages = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Greek, "el"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+}
+
+// stopWords holds common short words for languages written in Latin
+// script. One-letter words are left out: they are shared by too many
+// languages, such as "a" in English and Portuguese.
+var stopWords = map[string][]string{
+	"en": {"the", "and", "is", "are", "you", "what", "how", "of", "to", "it", "my", "this", "with"},
+	"es": {"el", "la", "los", "las", "es", "que", "de", "por", "para", "como", "qué", "cómo", "mi", "con"},
+	"fr": {"le", "la", "les", "est", "et", "que", "de", "des", "un", "une", "pour", "je", "vous", "mon", "avec"},
+	"de": {"der", "die", "das", "ist", "und", "nicht", "ich", "du", "wie", "was", "ein", "eine", "mit", "mein"},
+	"it": {"il", "la", "che", "di", "un", "una", "per", "come", "cosa", "non", "mio", "con"},
+	"pt": {"os", "as", "que", "de", "um", "uma", "para", "como", "não", "meu", "com"},
+	"nl": {"de", "het", "een", "en", "is", "van", "ik", "je", "niet", "wat", "hoe", "mijn", "met"},
+}
+
+// minStopWords is how many common words Latin text must have before its
+// language is guessed.
+const minStopWords = 2
+
+// detectLanguage makes a best guess at the language of text, returning a
+// two-letter code or "" when it cannot tell. Non-Latin scripts decide the
+// language outright. Latin text is scored by counting common words, and
+// left undecided when it has too few or two languages tie.
+func detectLanguage(text string) string {
+	counts := make(map[string]int)
+	for _, r := range text {
+		for _, s := range scriptLanguages {
+			if unicode.Is(s.table, r) {
+				counts[s.lang]++
+				break
+			}
+		}
+	}
+	// Japanese text mixes kana with Han characters, so any kana wins.
+	if counts["ja"] > 0 {
+		return "ja"
+	}
+	best, bestCount := "", 0
+	for lang, n := range counts {
+		if n > bestCount || (n == bestCount && lang < best) {
+			best, bestCount = lang, n
+		}
+	}
+	if best != "" {
+		return best
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	scores := make(map[string]int)
+	for _, w := range words {
+		for lang, list := range stopWords {
+			for _, sw := range list {
+				if w == sw {
+					scores[lang]++
+					break
+				}
+			}
+		}
+	}
+	// Only guess when the best language has enough common words and more
+	// than any other.
+	runnerUp := 0
+	for lang, n := range scores {
+		switch {
+		case n > bestCount:
+			best, bestCount, runnerUp = lang, n, bestCount
+		case n > runnerUp:
+			runnerUp = n
+		}
+	}
+	if bestCount < minStopWords || bestCount == runnerUp {
+		return ""
+	}
+	return best
+}
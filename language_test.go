@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/adk/model"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"Hello", ""},
+		{"Tell me a joke", ""},
+		{"I have a question", ""},
+		{"What is the weather like?", "en"},
+		{"How are you doing with this?", "en"},
+		{"¿Qué es la vida para los poetas?", "es"},
+		{"Je ne sais pas ce que vous voulez dire avec ça", "fr"},
+		{"Ich weiß nicht, was das ist", "de"},
+		{"Non so cosa vuoi dire con questo", "it"},
+		{"Eu não sei o que é isso, meu amigo", "pt"},
+		{"Ik weet niet wat het is", "nl"},
+		{"de la", ""},
+		{"こんにちは世界", "ja"},
+		{"你好世界", "zh"},
+		{"안녕하세요", "ko"},
+		{"Привет, как дела?", "ru"},
+		{"Γειά σου κόσμε", "el"},
+		{"مرحبا بالعالم", "ar"},
+		{"שלום עולם", "he"},
+	}
+	for _, tt := range tests {
+		if got := detectLanguage(tt.text); got != tt.want {
+			t.Errorf("detectLanguage(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// systemText returns the system instruction sent with req.
+func systemText(req *model.LLMRequest) string {
+	if req.Config == nil || req.Config.SystemInstruction == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, p := range req.Config.SystemInstruction.Parts {
+		b.WriteString(p.Text)
+	}
+	return b.String()
+}
+
+func TestChatLanguageSystem(t *testing.T) {
+	es := writeFile(t, "es.txt", "Responde en español.")
+	fr := writeFile(t, "fr.txt", "Réponds en français.")
+	tests := []struct {
+		name string
+		msgs []string
+		want string
+	}{
+		{"spanish", []string{"¿Qué es la vida para los poetas?"}, "Responde en español."},
+		{"french", []string{"Je ne sais pas ce que vous voulez dire avec ça"}, "Réponds en français."},
+		{"undetected", []string{"Tell me a joke"}, "[default]"},
+		{"first message decides", []string{"¿Qué es la vida para los poetas?", "What is the weather like?"}, "Responde en español."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SYSTEM_INSTRUCTION", "[default]")
+			llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("ok")}}
+			c := newTestChat(t, llm, "-system-lang", "es="+es, "-system-lang", "fr="+fr)
+			for _, msg := range tt.msgs {
+				if rec := c.get(t, nil, "msg", msg, "node_id", "n"); rec.Code != 200 {
+					t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+				}
+			}
+			for i, call := range llm.calls {
+				if got := systemText(call); !strings.HasPrefix(got, tt.want) {
+					t.Errorf("call %d: system instruction starts %.30q, want %q", i+1, got, tt.want)
+				}
+			}
+		})
+	}
+}
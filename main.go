@@ -1,22 +1,28 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"flag"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
-	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
 	"go.opentelemetry.io/otel/sdk/trace"
-	"google.golang.org/adk/agent"
-	"google.golang.org/adk/runner"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
 	"google.golang.org/genai"
 )
 
@@ -32,24 +38,40 @@ func initTracer() (*trace.TracerProvider, error) {
 	return tp, nil
 }
 
-func main() {
-	var (
-		addr         string
-		token        string
-		system       string
-		searchSystem string
-		prefix       string
-		otelOut      bool
-	)
+// loadInstruction reads an instruction file, trimming trailing newlines.
+// A missing file is logged and yields an empty instruction.
+func loadInstruction(path, what string) (string, error) {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		slog.Warn(what+" instruction file not found, proceeding without it", "path", path)
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("%s instruction path %q is not a regular file", what, path)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	slog.Info("loaded "+what+" instructions", "path", path)
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
 
-	flag.StringVar(&addr, "addr", ":8080", "TCP host:port to listen on")
-	flag.StringVar(&system, "system", "system.txt", "Path to system instructions file")
-	flag.StringVar(&searchSystem, "search-system", "search_system.txt", "Path to search system instructions file")
-	flag.StringVar(&prefix, "prefix", "", "Prefix to include in response")
-	flag.BoolVar(&otelOut, "otel", false, "Output OpenTelemetry spans to stdout")
-	flag.Parse()
+func main() {
+	cfg, err := parseConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		slog.Error("failed to parse flags", "error", err)
+		os.Exit(2)
+	}
+	if err := cfg.validate(); err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
 
-	if otelOut {
+	if cfg.OTel {
 		tp, err := initTracer()
 		if err != nil {
 			slog.Error("failed to initialize tracer", "error", err)
@@ -62,173 +84,269 @@ func main() {
 		}()
 	}
 
-	token = os.Getenv("GEMINI_API_KEY")
-	if token == "" {
-		slog.Error("GEMINI_API_KEY is required")
-		os.Exit(1)
+	var httpClient *http.Client
+	if cfg.HTTPProxy != "" {
+		proxyURL, _ := url.Parse(cfg.HTTPProxy) // checked by validate
+		httpClient = newProxyClient(proxyURL)
+		slog.Info("using HTTP proxy for Gemini API", "proxy", proxyURL.Redacted())
 	}
 
-	meshAPIURL := os.Getenv("MESHMONITOR_API_URL")
-	meshAPIToken := os.Getenv("MESHMONITOR_API_TOKEN")
-	meshSource := os.Getenv("MESHMONITOR_SOURCE")
+	endpoint := "https://generativelanguage.googleapis.com/"
+	if cfg.BaseURL != "" {
+		endpoint = cfg.BaseURL
+	}
+	slog.Info("Gemini API endpoint", "url", endpoint)
 
-	meshAPITimeout := 15 * time.Second
-	if timeoutStr := os.Getenv("MESHMONITOR_API_TIMEOUT"); timeoutStr != "" {
-		if d, err := time.ParseDuration(timeoutStr); err == nil {
-			meshAPITimeout = d
-		} else {
-			slog.Warn("invalid MESHMONITOR_API_TIMEOUT, using default", "value", timeoutStr, "error", err)
-		}
+	var instructions atomic.Pointer[systemInstructions]
+	si, err := loadSystemInstructions(cfg.System, cfg.LangSystem, cfg.SystemVars)
+	if err != nil {
+		slog.Error("failed to load system instructions", "error", err)
+		os.Exit(1)
 	}
+	instructions.Store(si)
 
-	var systemInstruction string
-	if content, err := os.ReadFile(system); err == nil {
-		systemInstruction = string(content)
-		slog.Info("loaded system instructions", "path", system)
-	} else {
-		if os.IsNotExist(err) {
-			slog.Warn("system instruction file not found, proceeding without it", "path", system)
-		} else {
-			slog.Error("failed to read system instruction file", "error", err)
-			os.Exit(1)
+	// Reload the system instructions on SIGHUP, keeping the old ones if the
+	// new ones fail to load.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			si, err := loadSystemInstructions(cfg.System, cfg.LangSystem, cfg.SystemVars)
+			if err != nil {
+				slog.Error("failed to reload system instructions", "error", err)
+				continue
+			}
+			instructions.Store(si)
+			slog.Info("reloaded system instructions")
 		}
+	}()
+
+	searchSystemInstruction, err := loadInstruction(cfg.SearchSystem, "search system")
+	if err != nil {
+		slog.Error("failed to load search system instructions", "error", err)
+		os.Exit(1)
 	}
 
-	var searchSystemInstruction string
-	if content, err := os.ReadFile(searchSystem); err == nil {
-		searchSystemInstruction = string(content)
-		slog.Info("loaded search system instructions", "path", searchSystem)
-	} else {
-		if os.IsNotExist(err) {
-			slog.Warn("search system instruction file not found, proceeding without it", "path", searchSystem)
-		} else {
-			slog.Error("failed to read search system instruction file", "error", err)
+	var blocked blocklist
+	if cfg.BlockFile != "" {
+		blocked, err = loadBlocklist(cfg.BlockFile)
+		if err != nil {
+			slog.Error("failed to load blocklist", "error", err)
 			os.Exit(1)
 		}
+		slog.Info("loaded blocklist", "path", cfg.BlockFile, "patterns", len(blocked))
 	}
 
-	// const aiModel = "gemini-2.5-flash-lite"
-	const aiModel = "gemini-3.1-flash-lite"
+	// ctx is cancelled when the process is asked to stop.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	run, err := buildRunner(context.Background(), token, aiModel, systemInstruction, searchSystemInstruction, meshAPIURL, meshAPIToken, meshSource, meshAPITimeout)
+	sessionService := session.InMemoryService()
+
+	llm, err := newLLM(ctx, cfg, httpClient)
 	if err != nil {
-		slog.Error("failed to create runner", "error", err)
+		slog.Error("failed to create model", "error", err)
 		os.Exit(1)
 	}
 
-	// Create a new ServeMux
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		msg := r.URL.Query().Get("msg")
-		if msg == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("msg query parameter is required"))
-			return
+	if cfg.RetryEmpty > 0 {
+		llm = &retryEmptyModel{LLM: llm, retries: cfg.RetryEmpty}
+	}
+
+	metrics := make(map[string]func() any)
+	if cfg.CBThreshold > 0 {
+		breaker := newBreakerModel(llm, cfg.CBThreshold, cfg.CBCooldown)
+		metrics["circuit_breaker"] = breaker.metrics
+		llm = breaker
+	}
+
+	beforeModel := []llmagent.BeforeModelCallback{sessionModelCallback(), generationCallback()}
+	if cfg.HistoryMaxBytes > 0 {
+		var summarizer model.LLM
+		if cfg.HistoryCompaction == "summarize" {
+			summarizer = llm
 		}
+		compactor := newHistoryCompactor(summarizer, cfg.HistoryMaxBytes, cfg.HistoryKeepFraction)
+		sessionService = &forgettingService{Service: sessionService, forget: compactor.forget}
+		beforeModel = append(beforeModel, compactor.callback())
+	}
+	if cfg.PromptPrefix != "" || cfg.PromptSuffix != "" {
+		beforeModel = append(beforeModel, wrapPromptCallback(cfg.PromptPrefix, cfg.PromptSuffix))
+	}
+	if cfg.SystemDelimiter != "" {
+		beforeModel = append(beforeModel, splitInstructionCallback(cfg.SystemDelimiter))
+	}
+	if cfg.InjectTime {
+		beforeModel = append(beforeModel, injectTimeCallback(cfg.TimeFormat))
+	}
 
-		metadata := make(map[string]any)
-		for _, key := range []string{"channel", "node_id", "short_name", "long_name", "hops", "snr", "rssi", "node_count", "direct_count"} {
-			value := r.URL.Query().Get(key)
-			if value != "" {
-				switch key {
-				case "hops", "node_count", "direct_count":
-					if i, err := strconv.Atoi(value); err == nil {
-						metadata[key] = i
-					} else {
-						metadata[key] = value
-					}
-				case "snr", "rssi":
-					if f, err := strconv.ParseFloat(value, 64); err == nil {
-						metadata[key] = f
-					} else {
-						metadata[key] = value
-					}
-				default:
-					metadata[key] = value
-				}
-			}
+	run, err := buildRunner(ctx, cfg, sessionService, llm, &instructions, searchSystemInstruction, beforeModel)
+	if err != nil {
+		slog.Error("failed to create runner", "error", err)
+		os.Exit(1)
+	}
+
+	if cfg.Oneshot {
+		if err := runOneshot(ctx, run, os.Stdin, os.Stdout, &cfg.Filter); err != nil {
+			slog.Error("oneshot failed", "error", err)
+			os.Exit(1)
 		}
+		return
+	}
 
-		sessionID := r.URL.Query().Get("channel")
-		if sessionID == "DM" || sessionID == "" {
-			sessionID = r.URL.Query().Get("node_id")
+	if cfg.Replay != "" {
+		f, err := os.Open(cfg.Replay)
+		if err != nil {
+			slog.Error("failed to open replay log", "error", err)
+			os.Exit(1)
 		}
-		if sessionID == "" {
-			sessionID = "default"
+		err = runReplay(ctx, run, f, os.Stdout, &cfg.Filter, cfg.MetadataTarget)
+		f.Close()
+		if err != nil {
+			slog.Error("replay failed", "error", err)
+			os.Exit(1)
 		}
-		// slog.Info("creating new chat", "session_id", sessionID)
+		return
+	}
 
-		ctx := r.Context()
-		userContent := &genai.Content{
-			Role:  "user",
-			Parts: []*genai.Part{{Text: msg}},
+	var spill *spillStore
+	if cfg.SpillThreshold > 0 {
+		spill, err = newSpillStore(cfg.SpillTTL)
+		if err != nil {
+			slog.Error("failed to create spill directory", "error", err)
+			os.Exit(1)
 		}
+		defer spill.Close()
+	}
 
-		var opts []runner.RunOption
-		if len(metadata) > 0 {
-			opts = append(opts, runner.WithStateDelta(metadata))
+	var reqLog *requestLog
+	if cfg.RequestLog != "" {
+		reqLog, err = openRequestLog(cfg.RequestLog, cfg.RequestLogRedact)
+		if err != nil {
+			slog.Error("failed to open request log", "error", err)
+			os.Exit(1)
 		}
+		defer reqLog.Close()
+		slog.Info("logging requests", "path", cfg.RequestLog, "redact", cfg.RequestLogRedact)
+	}
 
-		var respText string
-		events := run.Run(ctx, sessionID, sessionID, userContent, agent.RunConfig{}, opts...)
-		for event, err := range events {
-			if err != nil {
-				slog.Error("failed to get response from AI", "error", err)
-				http.Error(w, "failed to get response from AI", http.StatusInternalServerError)
-				return
-			}
-			if event.Content != nil {
-				for _, part := range event.Content.Parts {
-					if part.Text != "" {
-						respText += part.Text
-					}
-				}
-			}
+	// The embedding and files endpoints use the Gemini API directly.
+	var genaiClient *genai.Client
+	var files *fileStore
+	if !cfg.DryRun && len(cfg.Tokens) > 0 {
+		genaiClient, err = genai.NewClient(ctx, newClientConfig(cfg.Tokens[0], httpClient, cfg.BaseURL))
+		if err != nil {
+			slog.Error("failed to create Gemini client", "error", err)
+			os.Exit(1)
 		}
+		files = &fileStore{client: genaiClient, maxSize: cfg.MaxUploadBytes}
+	}
+
+	locks := newSessionLocks()
+	var backoff *sessionBackoff
+	if cfg.SessionFailureThreshold > 0 {
+		backoff = newSessionBackoff(cfg.SessionFailureThreshold, cfg.SessionBackoff, cfg.SessionBackoffMax)
+	}
 
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		if len(prefix) > 0 {
-			w.Write([]byte(prefix))
+	// Create a new ServeMux
+	mux := http.NewServeMux()
+	var draining atomic.Bool
+	signer := &cookieSigner{}
+	for secret := range strings.SplitSeq(cfg.CookieSecret, ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			signer.secrets = append(signer.secrets, []byte(secret))
 		}
-		w.Write([]byte(respText))
-		if len([]byte(respText)) > 200 {
-			slog.Warn("response too long", "length", len([]byte(respText)), "response", respText)
+	}
+	chat := chatHandler(cfg, run, sessionService, blocked, files, spill, reqLog, backoff)
+	if cfg.QueueWorkers > 0 {
+		queue := newAdmissionQueue(cfg.QueueWorkers, cfg.QueueSize, cfg.QueueTimeout, errorWriter(cfg.ErrorFormat))
+		metrics["queue"] = queue.metrics
+		chat = queue.middleware(chat)
+	}
+	// Take the session lock before queueing, so that requests waiting for
+	// their session do not hold up a worker.
+	chat = locks.middleware(chat)
+	if cfg.IdempotencyTTL > 0 {
+		chat = newIdempotencyCache(cfg.IdempotencyTTL).middleware(chat)
+	}
+	registerChat(mux, cfg, &draining, signer, chat, batchHandler(cfg, run, sessionService, blocked))
+	if genaiClient != nil {
+		mux.Handle("POST /embed", embedHandler(genaiClient, cfg.EmbedModel))
+		mux.Handle("POST /files", files.uploadHandler())
+	}
+	mux.Handle("GET /ws", rejectWhenDraining(&draining, writeError, sessionCookies(signer, false, wsHandler(cfg, run, sessionService, blocked, locks, backoff))))
+	if spill != nil {
+		mux.HandleFunc("GET /media/{id}", spill.handler())
+	}
+	mux.HandleFunc("GET /version", versionHandler)
+	mux.HandleFunc("GET /healthz", healthzHandler(&draining))
+	mux.HandleFunc("GET /readyz", readyzHandler(&draining))
+	mux.HandleFunc("GET /metrics", metricsHandler(metrics))
+	mux.HandleFunc("GET /favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		if cfg.Favicon == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
 		}
+		http.ServeFile(w, r, cfg.Favicon)
 	})
-	mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusNotFound)
-	})
+	if cfg.UI {
+		mux.Handle("GET /ui/", sessionCookies(signer, true, uiHandler()))
+		mux.Handle("GET /ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently))
+		slog.Info("web UI enabled", "path", "/ui/")
+	}
+	if cfg.AdminToken != "" {
+		var checkModel func(ctx context.Context, name string) error
+		if genaiClient != nil && cfg.Provider == "gemini" {
+			checkModel = func(ctx context.Context, name string) error {
+				_, err := genaiClient.Models.Get(ctx, name, nil)
+				return err
+			}
+		}
+		registerAdmin(mux, cfg.AdminToken, sessionService, &draining, checkModel)
+		mux.Handle("GET /config", requireToken(cfg.AdminToken, configHandler(map[string]any{
+			"flags":               cfg.Flags,
+			"version":             version,
+			"gemini_api_keys":     len(cfg.Tokens),
+			"openai_api_key_set":  os.Getenv("OPENAI_API_KEY") != "",
+			"mesh_api_url":        cfg.MeshAPIURL,
+			"mesh_api_token_set":  cfg.MeshAPIToken != "",
+			"mesh_source":         cfg.MeshSource,
+			"mesh_api_timeout":    cfg.MeshAPITimeout.String(),
+			"gemini_api_endpoint": endpoint,
+		})))
+	} else {
+		slog.Info("CHATTY_ADMIN_TOKEN not set, admin endpoints disabled")
+	}
 
 	// Wrap the mux with the logging middleware
-	loggedMux := loggingMiddleware(mux)
+	loggedMux := loggingMiddleware(mux, cfg.PriceInput, cfg.PriceOutput, cfg.LogSampleRate, cfg.SlowThreshold)
 
-	// Create the HTTP server
-	srv := &http.Server{
-		Addr:    addr,
-		Handler: loggedMux,
-	}
+	srv := newServer(cfg, loggedMux)
 
 	// Channel to listen for errors coming from the listener.
 	serverErrors := make(chan error, 1)
 
+	if cfg.Warmup && !cfg.DryRun {
+		go warmUp(ctx, llm)
+	}
+
 	// Start the server
 	go func() {
-		slog.Info("Starting server", "addr", addr)
+		bi := getBuildInfo()
+		slog.Info("Starting server", "addr", cfg.Addr, "version", bi.Version, "commit", bi.Commit, "go_version", bi.GoVersion)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			serverErrors <- err
 		}
 	}()
 
-	// Channel to listen for interrupt signals
-	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
-
 	// Block until we receive our signal.
 	select {
 	case err := <-serverErrors:
 		slog.Error("server error", "error", err)
-	case sig := <-shutdown:
-		slog.Info("shutdown started", "signal", sig)
+	case <-ctx.Done():
+		// Restore default signal handling so a second signal kills the process.
+		stop()
+		slog.Info("shutdown started", "cause", context.Cause(ctx))
 
 		// Give outstanding requests a deadline for completion.
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -243,7 +361,55 @@ func main() {
 		}
 	}
 
-	slog.Info("shutdown complete", "addr", addr)
+	// The genai client has no Close method, so release the pooled upstream
+	// connections directly once the server has stopped using them.
+	closeIdleConnections(httpClient, http.DefaultClient)
+
+	slog.Info("shutdown complete", "addr", cfg.Addr)
+}
+
+// registerChat adds the chat endpoints to mux. Each is registered for its
+// method only, so that, say, a POST to / gets 405 rather than a reply that
+// ignored its body.
+func registerChat(mux *http.ServeMux, cfg *config, draining *atomic.Bool, signer *cookieSigner, chat, batch http.Handler) {
+	mux.Handle("GET /{$}", cacheControl(cfg.ResponseCacheControl, rejectWhenDraining(draining, errorWriter(cfg.ErrorFormat), sessionCookies(signer, false, chat))))
+	mux.Handle("POST /batch", cacheControl(cfg.ResponseCacheControl, rejectWhenDraining(draining, writeError, batch)))
+}
+
+// closeIdleConnections closes the idle connections of each client that is
+// not nil.
+func closeIdleConnections(clients ...*http.Client) {
+	for _, c := range clients {
+		if c != nil {
+			c.CloseIdleConnections()
+		}
+	}
+}
+
+// newServer returns the HTTP server for handler, with the address and
+// timeouts from cfg. Streamed replies extend their own write deadline; see
+// sseWriter.
+func newServer(cfg *config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
+// cacheControl sets the Cache-Control header of responses from next to
+// value, unless value is empty.
+func cacheControl(value string, next http.Handler) http.Handler {
+	if value == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", value)
+		next.ServeHTTP(w, r)
+	})
 }
 
 type loggingResponseWriter struct {
@@ -256,10 +422,21 @@ func (w *loggingResponseWriter) Write(b []byte) (int, error) {
 	return w.ResponseWriter.Write(b)
 }
 
-func loggingMiddleware(next http.Handler) http.Handler {
+func (w *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// loggingMiddleware logs each request. When sampleRate is more than one,
+// only 1 in sampleRate successful requests is logged, but failed requests
+// and those taking slowThreshold or longer always are.
+func loggingMiddleware(next http.Handler, priceInput, priceOutput float64, sampleRate int, slowThreshold time.Duration) http.Handler {
+	var successes atomic.Uint64
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
+		ctx, usage := withUsage(r.Context())
+		r = r.WithContext(ctx)
+
 		var wr loggingResponseWriter
 		wr.ResponseWriter = w
 
@@ -271,15 +448,30 @@ func loggingMiddleware(next http.Handler) http.Handler {
 
 		next.ServeHTTP(wrapped, r)
 
-		slog.Info("request completed",
+		duration := time.Since(start)
+		if sampleRate > 1 && wrapped.statusCode < http.StatusBadRequest && (slowThreshold <= 0 || duration < slowThreshold) {
+			if successes.Add(1)%uint64(sampleRate) != 1 {
+				return
+			}
+		}
+
+		attrs := []any{
 			"method", r.Method,
 			"path", r.URL.Path,
 			"query", r.URL.Query(),
 			"status", wrapped.statusCode,
 			// "headers", r.Header,
-			"duration", time.Since(start),
+			"duration", duration,
 			"response", wr.result.String(),
-		)
+		}
+		if usage.promptTokens.Load() > 0 || usage.responseTokens.Load() > 0 {
+			attrs = append(attrs,
+				"prompt_tokens", usage.promptTokens.Load(),
+				"response_tokens", usage.responseTokens.Load(),
+				"cost", usage.cost(priceInput, priceOutput),
+			)
+		}
+		slog.Info("request completed", attrs...)
 	})
 }
 
@@ -292,3 +484,13 @@ func (w *wrappedWriter) WriteHeader(statusCode int) {
 	w.ResponseWriter.WriteHeader(statusCode)
 	w.statusCode = statusCode
 }
+
+func (w *wrappedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack lets WebSocket upgrades take over the underlying connection.
+func (w *wrappedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.statusCode = http.StatusSwitchingProtocols
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+func TestNewServer(t *testing.T) {
+	tests := []struct {
+		args                          []string
+		read, readHeader, write, idle time.Duration
+	}{
+		{nil, 30 * time.Second, 30 * time.Second, 5 * time.Minute, 2 * time.Minute},
+		{[]string{"-read-timeout", "5s", "-write-timeout", "1m", "-idle-timeout", "10s"}, 5 * time.Second, 5 * time.Second, time.Minute, 10 * time.Second},
+		{[]string{"-write-timeout", "0"}, 30 * time.Second, 30 * time.Second, 0, 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		cfg, _ := testConfig(t, append([]string{"-addr", ":9999"}, tt.args...)...)
+		srv := newServer(cfg, http.NotFoundHandler())
+		if srv.Addr != ":9999" {
+			t.Errorf("Addr = %q", srv.Addr)
+		}
+		if srv.ReadTimeout != tt.read || srv.ReadHeaderTimeout != tt.readHeader || srv.WriteTimeout != tt.write || srv.IdleTimeout != tt.idle {
+			t.Errorf("%v: timeouts read %v, header %v, write %v, idle %v; want %v, %v, %v, %v", tt.args,
+				srv.ReadTimeout, srv.ReadHeaderTimeout, srv.WriteTimeout, srv.IdleTimeout,
+				tt.read, tt.readHeader, tt.write, tt.idle)
+		}
+	}
+}
+
+// idleTransport counts calls to CloseIdleConnections.
+type idleTransport struct {
+	http.RoundTripper
+	closed int
+}
+
+func (t *idleTransport) CloseIdleConnections() { t.closed++ }
+
+func TestCloseIdleConnections(t *testing.T) {
+	a, b := &idleTransport{}, &idleTransport{}
+	closeIdleConnections(&http.Client{Transport: a}, nil, &http.Client{Transport: b})
+	if a.closed != 1 || b.closed != 1 {
+		t.Errorf("closed = %d, %d; want 1, 1", a.closed, b.closed)
+	}
+}
+
+func TestRegisterChatMethods(t *testing.T) {
+	c := newTestChat(t, echoModel{})
+	mux := http.NewServeMux()
+	var draining atomic.Bool
+	registerChat(mux, c.cfg, &draining, &cookieSigner{}, c, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("batch"))
+	}))
+	tests := []struct {
+		method, target string
+		status         int
+		allow          string
+	}{
+		{http.MethodGet, "/?msg=hi", http.StatusOK, ""},
+		{http.MethodHead, "/?msg=hi", http.StatusOK, ""},
+		{http.MethodPost, "/?msg=hi", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodDelete, "/", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodPost, "/batch", http.StatusOK, ""},
+		{http.MethodGet, "/batch", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodGet, "/nothing?msg=hi", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, strings.NewReader("")))
+		if rec.Code != tt.status || rec.Header().Get("Allow") != tt.allow {
+			t.Errorf("%s %s: got %d with Allow %q, want %d with Allow %q", tt.method, tt.target, rec.Code, rec.Header().Get("Allow"), tt.status, tt.allow)
+		}
+	}
+}
+
+// captureLogs sends the default logger's output, as JSON, to the returned
+// buffer until the test ends.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return &buf
+}
+
+// completedRequests returns the "request completed" log records in buf.
+func completedRequests(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var rec map[string]any
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec["msg"] == "request completed" {
+			records = append(records, rec)
+		}
+	}
+	return records
+}
+
+func TestLoggingMiddlewareUsage(t *testing.T) {
+	llm := &fakeModel{name: "fake", replies: []fakeReply{{resps: []*model.LLMResponse{{
+		Content:       genai.NewContentFromText("Hello", genai.RoleModel),
+		UsageMetadata: &genai.GenerateContentResponseUsageMetadata{PromptTokenCount: 1000, CandidatesTokenCount: 500},
+		FinishReason:  genai.FinishReasonStop,
+	}}}}}
+	c := newTestChat(t, llm)
+	h := loggingMiddleware(c, 0.5, 1, 0, 0)
+	logs := captureLogs(t)
+
+	for _, target := range []string{"/?msg=hi", "/?msg="} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+	records := completedRequests(t, logs)
+	if len(records) != 2 {
+		t.Fatalf("logged %d requests, want 2", len(records))
+	}
+	if got := records[0]; got["prompt_tokens"] != 1000.0 || got["response_tokens"] != 500.0 || got["cost"] != 1.0 {
+		t.Errorf("usage logged = %v, %v, %v; want 1000, 500, 1", got["prompt_tokens"], got["response_tokens"], got["cost"])
+	}
+	if _, ok := records[1]["cost"]; ok {
+		t.Errorf("request without model call logged cost %v", records[1]["cost"])
+	}
+}
+
+func TestLoggingMiddlewareSampling(t *testing.T) {
+	h := loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/error":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/slow":
+			time.Sleep(20 * time.Millisecond)
+		}
+	}), 0, 0, 4, 10*time.Millisecond)
+	logs := captureLogs(t)
+
+	paths := map[string]int{"/ok": 20, "/error": 5, "/slow": 3}
+	for path, n := range paths {
+		for range n {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		}
+	}
+	logged := make(map[string]int)
+	for _, rec := range completedRequests(t, logs) {
+		logged[rec["path"].(string)]++
+	}
+	want := map[string]int{"/ok": 5, "/error": 5, "/slow": 3}
+	if !maps.Equal(logged, want) {
+		t.Errorf("logged %v, want %v", logged, want)
+	}
+}
+
+func TestRegisterChatCacheControl(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"default", nil, "no-store"},
+		{"custom", []string{"-response-cache-control", "private, max-age=0"}, "private, max-age=0"},
+		{"omitted", []string{"-response-cache-control", ""}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestChat(t, echoModel{}, tt.args...)
+			mux := http.NewServeMux()
+			var draining atomic.Bool
+			registerChat(mux, c.cfg, &draining, &cookieSigner{}, c, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("batch"))
+			}))
+			requests := []struct {
+				method, target string
+				accept         string
+				draining       bool
+				status         int
+			}{
+				{http.MethodGet, "/?msg=hi", "", false, http.StatusOK},
+				{http.MethodGet, "/?msg=hi", "text/event-stream", false, http.StatusOK},
+				{http.MethodGet, "/", "", false, http.StatusBadRequest},
+				{http.MethodPost, "/batch", "", false, http.StatusOK},
+				{http.MethodGet, "/?msg=hi", "", true, http.StatusServiceUnavailable},
+			}
+			for _, r := range requests {
+				draining.Store(r.draining)
+				req := httptest.NewRequest(r.method, r.target, strings.NewReader(""))
+				req.Header.Set("Accept", r.accept)
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, req)
+				if rec.Code != r.status {
+					t.Errorf("%s %s: status = %d, want %d", r.method, r.target, rec.Code, r.status)
+				}
+				want := tt.want
+				if r.accept == "text/event-stream" {
+					if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+						t.Errorf("%s %s: Content-Type = %q, want a stream", r.method, r.target, ct)
+					}
+					// A stream without a configured value is still not cached.
+					if want == "" {
+						want = "no-cache"
+					}
+				}
+				if got := rec.Header().Get("Cache-Control"); got != want {
+					t.Errorf("%s %s (Accept %q): Cache-Control = %q, want %q", r.method, r.target, r.accept, got, want)
+				}
+			}
+		})
+	}
+}
@@ -2,34 +2,110 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
-	"time"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
 
+	"google.golang.org/adk/agent"
 	"google.golang.org/adk/agent/llmagent"
-	"google.golang.org/adk/model/gemini"
+	"google.golang.org/adk/model"
 	"google.golang.org/adk/runner"
 	"google.golang.org/adk/session"
 	"google.golang.org/adk/tool"
 	"google.golang.org/adk/tool/agenttool"
 	"google.golang.org/adk/tool/geminitool"
+	"google.golang.org/adk/util/instructionutil"
 	"google.golang.org/genai"
 
 	"github.com/ancientlore/chatty/meshmtr"
 )
 
-func buildRunner(ctx context.Context, token, modelName, systemInstruction, searchSystemInstruction, meshAPIURL, meshAPIToken, meshSource string, meshAPITimeout time.Duration) (*runner.Runner, error) {
-	// Initialize the genai client config
+// appName is the ADK application name under which sessions are stored.
+const appName = "chatty"
+
+// newProxyClient returns an HTTP client that sends requests through the
+// proxy at proxyURL.
+func newProxyClient(proxyURL *url.URL) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	return &http.Client{Transport: transport}
+}
+
+// newClientConfig returns the genai client configuration for an API key.
+func newClientConfig(token string, httpClient *http.Client, baseURL string) *genai.ClientConfig {
 	clientConfig := &genai.ClientConfig{
-		APIKey:  token,
-		Backend: genai.BackendGeminiAPI,
+		APIKey:     token,
+		Backend:    genai.BackendGeminiAPI,
+		HTTPClient: httpClient,
+	}
+	if baseURL != "" {
+		clientConfig.HTTPOptions.BaseURL = baseURL
+	}
+	return clientConfig
+}
+
+// newLLM creates the model for cfg: the echo model for -dry-run, or the
+// -provider's model with its fallbacks.
+func newLLM(ctx context.Context, cfg *config, httpClient *http.Client) (model.LLM, error) {
+	switch {
+	case cfg.DryRun:
+		slog.Warn("dry-run mode active, the Gemini API will not be called")
+		return echoModel{}, nil
+	case cfg.Provider == "openai":
+		return buildOpenAIModel(cfg.Model, cfg.FallbackModels, cfg.OpenAIBaseURL, os.Getenv("OPENAI_API_KEY"), httpClient), nil
+	default:
+		return buildModel(ctx, cfg, httpClient)
+	}
+}
+
+// buildModel creates the Gemini model named by cfg, with its fallbacks.
+func buildModel(ctx context.Context, cfg *config, httpClient *http.Client) (model.LLM, error) {
+	// newModel creates the named model, rotating between API keys when
+	// more than one is configured.
+	newModel := func(name string) (model.LLM, error) {
+		var models []model.LLM
+		for _, token := range cfg.Tokens {
+			m, err := newGeminiModel(ctx, name, newClientConfig(token, httpClient, cfg.BaseURL))
+			if err != nil {
+				return nil, err
+			}
+			models = append(models, m)
+		}
+		if len(models) == 1 {
+			return models[0], nil
+		}
+		return newRotatingModel(models, cfg.KeyCooldown), nil
 	}
 
 	// Create the Gemini model
-	geminiModel, err := gemini.NewModel(ctx, modelName, clientConfig)
+	geminiModel, err := newModel(cfg.Model)
 	if err != nil {
 		return nil, err
 	}
+	if len(cfg.Tokens) > 1 {
+		slog.Info("Rotating between API keys", "keys", len(cfg.Tokens))
+	}
+
+	if len(cfg.FallbackModels) > 0 {
+		chain := &fallbackModel{models: []model.LLM{geminiModel}}
+		for _, name := range cfg.FallbackModels {
+			m, err := newModel(name)
+			if err != nil {
+				return nil, err
+			}
+			chain.models = append(chain.models, m)
+		}
+		slog.Info("Using fallback models", "model", cfg.Model, "fallback", cfg.FallbackModels)
+		geminiModel = chain
+	}
 
+	return geminiModel, nil
+}
+
+func buildRunner(ctx context.Context, cfg *config, sessionService session.Service, geminiModel model.LLM, instructions *atomic.Pointer[systemInstructions], searchSystemInstruction string, beforeModel []llmagent.BeforeModelCallback) (*runner.Runner, error) {
 	const extraContext = `Perspective & Telemetry Rules:
 - You (Gemma) are a chatbot running on the host MeshMonitor device.
 - All telemetry, node list details, and network statistics retrieved by you via tools (or in the metadata below) are measured relative to YOUR device (the chatbot's node/antenna), NOT the user's device.
@@ -68,8 +144,8 @@ Here is some information about the network that is visible to you:
 		agenttool.New(searchAgent, nil),
 	}
 
-	if meshAPIURL != "" && meshAPIToken != "" {
-		meshTools, err := meshmtr.NewTools(meshAPIURL, meshAPIToken, meshSource, meshAPITimeout)
+	if cfg.MeshAPIURL != "" && cfg.MeshAPIToken != "" {
+		meshTools, err := meshmtr.NewTools(cfg.MeshAPIURL, cfg.MeshAPIToken, cfg.MeshSource, cfg.MeshAPITimeout)
 		if err != nil {
 			return nil, err
 		}
@@ -82,11 +158,11 @@ Here is some information about the network that is visible to you:
 
 	// Create the main agent
 	agentCfg := llmagent.Config{
-		Name:              "chat_agent",
-		Description:       "A smart assistant handling chat communications.",
-		Model:             geminiModel,
-		GlobalInstruction: systemInstruction + "\n" + extraContext,
-		Tools:             tools,
+		Name:                 "chat_agent",
+		Description:          "A smart assistant handling chat communications.",
+		Model:                geminiModel,
+		Tools:                tools,
+		BeforeModelCallbacks: beforeModel,
 		/*
 			GenerateContentConfig: &genai.GenerateContentConfig{
 				ToolConfig: &genai.ToolConfig{
@@ -96,16 +172,29 @@ Here is some information about the network that is visible to you:
 		*/
 	}
 
+	// The system instructions may be reloaded, and may depend on the
+	// language detected on the session's first message, so they are
+	// supplied by a provider. A provider does not get template
+	// substitution, so inject the session state here.
+	agentCfg.GlobalInstructionProvider = func(ctx agent.ReadonlyContext) (string, error) {
+		var lang string
+		if v, err := ctx.ReadonlyState().Get(languageKey); err == nil {
+			lang = fmt.Sprint(v)
+		}
+		instruction := instructions.Load().forLanguage(lang)
+		return instructionutil.InjectSessionState(ctx, instruction+"\n"+extraContext)
+	}
+
 	chatAgent, err := llmagent.New(agentCfg)
 	if err != nil {
 		return nil, err
 	}
 
-	// Create the runner bounded to an in-memory session service
+	// Create the runner bounded to the session service
 	runnerCfg := runner.Config{
-		AppName:           "chatty",
+		AppName:           appName,
 		Agent:             chatAgent,
-		SessionService:    session.InMemoryService(),
+		SessionService:    sessionService,
 		AutoCreateSession: true,
 	}
 
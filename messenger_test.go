@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+func TestNewClientConfig(t *testing.T) {
+	client := &http.Client{}
+	tests := []struct {
+		name       string
+		httpClient *http.Client
+		baseURL    string
+	}{
+		{"defaults", nil, ""},
+		{"base URL", nil, "http://localhost:9999/"},
+		{"proxy client", client, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClientConfig("key", tt.httpClient, tt.baseURL)
+			if c.APIKey != "key" || c.Backend != genai.BackendGeminiAPI {
+				t.Errorf("APIKey = %q, Backend = %v", c.APIKey, c.Backend)
+			}
+			if c.HTTPClient != tt.httpClient {
+				t.Errorf("HTTPClient = %p, want %p", c.HTTPClient, tt.httpClient)
+			}
+			if c.HTTPOptions.BaseURL != tt.baseURL {
+				t.Errorf("BaseURL = %q, want %q", c.HTTPOptions.BaseURL, tt.baseURL)
+			}
+		})
+	}
+}
+
+// geminiRequest is a request with a single user message.
+func geminiRequest(text string) *model.LLMRequest {
+	return &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText(text, genai.RoleUser)}}
+}
+
+func TestBuildModelBaseURL(t *testing.T) {
+	g := newFakeGemini(t)
+	cfg, _ := testConfig(t, "-api-keys", "k1", "-base-url", g.URL+"/", "-model", "test-model")
+	llm, err := buildModel(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := drain(llm, geminiRequest("hi")); err != nil {
+		t.Fatal(err)
+	}
+	calls := g.calls()
+	if len(calls) != 1 || calls[0].Model != "test-model" || calls[0].Key != "k1" {
+		t.Errorf("requests = %+v, want one for test-model with key k1", calls)
+	}
+}
+
+func TestBuildModelProxy(t *testing.T) {
+	// The API host does not exist, so the request only arrives if it is
+	// sent through the proxy.
+	proxy := newFakeGemini(t)
+	proxyURL, _ := url.Parse(proxy.URL)
+	cfg, _ := testConfig(t, "-api-keys", "k1", "-base-url", "http://gemini.invalid/")
+	llm, err := buildModel(context.Background(), cfg, newProxyClient(proxyURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := drain(llm, geminiRequest("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if calls := proxy.calls(); len(calls) != 1 {
+		t.Errorf("proxy got %d requests, want 1", len(calls))
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// metadataKeys are the query parameters passed to the model as metadata,
+// most important first.
+var metadataKeys = []string{"channel", "node_id", "short_name", "long_name", "hops", "snr", "rssi", "node_count", "direct_count"}
+
+// capMetadata bounds the total size of the keys and values in metadata to
+// maxBytes, so that large values cannot crowd out the prompt. Keys are kept
+// in the order of metadataKeys until one does not fit. It returns the keys
+// that were removed. A maxBytes of zero or less means no limit.
+func capMetadata(metadata map[string]any, maxBytes int) (dropped []string) {
+	if maxBytes <= 0 {
+		return nil
+	}
+	size := 0
+	for _, k := range metadataKeys {
+		v, ok := metadata[k]
+		if !ok {
+			continue
+		}
+		size += len(k) + len(fmt.Sprint(v))
+		if size > maxBytes {
+			delete(metadata, k)
+			dropped = append(dropped, k)
+		}
+	}
+	return dropped
+}
+
+// formatMetadata renders metadata as a delimited block of "key: value"
+// lines, sorted by key, for inclusion in the user turn.
+func formatMetadata(metadata map[string]any) string {
+	var b strings.Builder
+	b.WriteString("[metadata]\n")
+	for _, k := range slices.Sorted(maps.Keys(metadata)) {
+		fmt.Fprintf(&b, "%s: %v\n", k, metadata[k])
+	}
+	b.WriteString("[/metadata]")
+	return b.String()
+}
+
+// userTurn returns the user content for msg and the session state to set
+// for it. The metadata goes into session state for the system instruction
+// template, or into the user turn itself, as target says. Any extra parts,
+// such as attached files, come before the text.
+func userTurn(msg string, metadata map[string]any, target string, extra ...*genai.Part) (*genai.Content, map[string]any) {
+	stateDelta := make(map[string]any)
+	userContent := &genai.Content{Role: genai.RoleUser}
+	text := msg
+	if len(metadata) > 0 {
+		switch target {
+		case "system":
+			maps.Copy(stateDelta, metadata)
+		case "user":
+			text = formatMetadata(metadata) + "\n" + msg
+		case "separate-part":
+			userContent.Parts = append(userContent.Parts, genai.NewPartFromText(formatMetadata(metadata)))
+		}
+	}
+	userContent.Parts = append(userContent.Parts, extra...)
+	userContent.Parts = append(userContent.Parts, genai.NewPartFromText(text))
+	return userContent, stateDelta
+}
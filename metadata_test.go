@@ -0,0 +1,167 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// lastUserText returns the text of the last message in contents.
+func lastUserText(contents []*genai.Content) string {
+	var b strings.Builder
+	for _, p := range contents[len(contents)-1].Parts {
+		b.WriteString(p.Text)
+	}
+	return b.String()
+}
+
+func TestChatMetadataMode(t *testing.T) {
+	tests := []struct {
+		mode string
+		want [2]bool // metadata sent with the first and second message
+	}{
+		{"every-turn", [2]bool{true, true}},
+		{"first-turn-only", [2]bool{true, false}},
+		{"none", [2]bool{false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("ok")}}
+			c := newTestChat(t, llm, "-metadata-mode", tt.mode, "-metadata-target", "user")
+			for _, msg := range []string{"one", "two"} {
+				if rec := c.get(t, nil, "msg", msg, "node_id", "n1", "short_name", "ab"); rec.Code != http.StatusOK {
+					t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+				}
+			}
+			for i, call := range llm.calls {
+				text := lastUserText(call.Contents)
+				if got := strings.Contains(text, "short_name: ab"); got != tt.want[i] {
+					t.Errorf("message %d = %q, metadata sent %v, want %v", i+1, text, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestChatMetadataSharedChannel sends messages from two nodes to one
+// channel session with the default -metadata-mode. The system instruction
+// must describe the latest sender, while the user target sends metadata
+// with the first message only.
+func TestChatMetadataSharedChannel(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantSystem [2]string // in the system instruction of each call
+		wantUser   [2]bool   // metadata sent with each message
+	}{
+		{"system", [2]string{"Node ID: n1 ", "Node ID: n2 "}, [2]bool{false, false}},
+		{"user", [2]string{"Node ID:  ", "Node ID:  "}, [2]bool{true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("ok")}}
+			c := newTestChat(t, llm, "-metadata-target", tt.target)
+			for _, node := range []string{"n1", "n2"} {
+				if rec := c.get(t, nil, "msg", "how is my battery?", "channel", "general", "node_id", node); rec.Code != http.StatusOK {
+					t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+				}
+			}
+			for i, call := range llm.calls {
+				if got := systemText(call); !strings.Contains(got, tt.wantSystem[i]) {
+					t.Errorf("call %d: system instruction does not contain %q", i+1, tt.wantSystem[i])
+				}
+				if got := strings.Contains(lastUserText(call.Contents), "[metadata]"); got != tt.wantUser[i] {
+					t.Errorf("call %d: metadata in message %v, want %v", i+1, got, tt.wantUser[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUserTurn(t *testing.T) {
+	metadata := map[string]any{"node_id": "n1", "hops": 2}
+	block := "[metadata]\nhops: 2\nnode_id: n1\n[/metadata]"
+	file := genai.NewPartFromURI("files/abc", "image/png")
+	tests := []struct {
+		target   string
+		metadata map[string]any
+		extra    []*genai.Part
+		texts    []string // the text of each part; "" for the file
+		state    bool
+	}{
+		{"system", metadata, nil, []string{"hi"}, true},
+		{"user", metadata, nil, []string{block + "\nhi"}, false},
+		{"separate-part", metadata, nil, []string{block, "hi"}, false},
+		{"separate-part", metadata, []*genai.Part{file}, []string{block, "", "hi"}, false},
+		{"user", nil, nil, []string{"hi"}, false},
+		{"separate-part", nil, []*genai.Part{file}, []string{"", "hi"}, false},
+	}
+	for _, tt := range tests {
+		content, state := userTurn("hi", tt.metadata, tt.target, tt.extra...)
+		if content.Role != genai.RoleUser {
+			t.Errorf("%s: role = %q", tt.target, content.Role)
+		}
+		var texts []string
+		for _, p := range content.Parts {
+			texts = append(texts, p.Text)
+		}
+		if !slices.Equal(texts, tt.texts) {
+			t.Errorf("%s with %d extra: parts = %q, want %q", tt.target, len(tt.extra), texts, tt.texts)
+		}
+		if got := len(state) > 0; got != tt.state {
+			t.Errorf("%s: state = %v, want set %v", tt.target, state, tt.state)
+		}
+		if tt.state && !maps.Equal(state, tt.metadata) {
+			t.Errorf("%s: state = %v, want %v", tt.target, state, tt.metadata)
+		}
+	}
+}
+
+func TestCapMetadata(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	tests := []struct {
+		name     string
+		metadata map[string]any
+		maxBytes int
+		want     []string // keys kept
+		dropped  []string
+	}{
+		{"under the limit", map[string]any{"channel": "c", "node_id": "n1"}, 20, []string{"channel", "node_id"}, nil},
+		{"exactly the limit", map[string]any{"channel": "c", "node_id": "n1"}, 17, []string{"channel", "node_id"}, nil},
+		{"last key dropped", map[string]any{"channel": "c", "node_id": "n1"}, 16, []string{"channel"}, []string{"node_id"}},
+		{"keys after a large one dropped", map[string]any{"node_id": "n1", "long_name": long, "hops": 1}, 50, []string{"node_id"}, []string{"long_name", "hops"}},
+		{"numbers counted as text", map[string]any{"snr": -7.25}, 8, []string{"snr"}, nil},
+		{"no limit", map[string]any{"long_name": long}, 0, []string{"long_name"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dropped := capMetadata(tt.metadata, tt.maxBytes)
+			if !slices.Equal(dropped, tt.dropped) {
+				t.Errorf("dropped %q, want %q", dropped, tt.dropped)
+			}
+			if got := slices.Sorted(maps.Keys(tt.metadata)); !slices.Equal(got, slices.Sorted(slices.Values(tt.want))) {
+				t.Errorf("kept %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatMaxMetadataBytes(t *testing.T) {
+	logs := captureLogs(t)
+	llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("ok")}}
+	c := newTestChat(t, llm, "-max-metadata-bytes", "40", "-metadata-target", "user")
+	rec := c.get(t, nil, "msg", "hi", "node_id", "n1", "short_name", "ab", "long_name", strings.Repeat("x", 100), "channel", "  ")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	want := "[metadata]\nnode_id: n1\nshort_name: ab\n[/metadata]\nhi"
+	if got := lastUserText(llm.calls[0].Contents); got != want {
+		t.Errorf("user turn = %q, want %q", got, want)
+	}
+	if !strings.Contains(logs.String(), `"dropped":["long_name"]`) {
+		t.Errorf("no warning naming the dropped key in %s", logs)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// metricsHandler reports a snapshot of each source as a JSON object keyed
+// by source name.
+func metricsHandler(sources map[string]func() any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		out := make(map[string]any, len(sources))
+		for name, f := range sources {
+			out[name] = f()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// timezoneHeader names the client's IANA time zone, such as
+// America/New_York, for -inject-time.
+const timezoneHeader = "X-Timezone"
+
+type timezoneKey struct{}
+
+// requestTimezone returns the location named by the request's X-Timezone
+// header, or nil if there is none.
+func requestTimezone(r *http.Request) (*time.Location, error) {
+	name := r.Header.Get(timezoneHeader)
+	if name == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown %s %q", timezoneHeader, name)
+	}
+	return loc, nil
+}
+
+// withTimezone returns a context whose model calls tell the time in loc.
+func withTimezone(ctx context.Context, loc *time.Location) context.Context {
+	return context.WithValue(ctx, timezoneKey{}, loc)
+}
+
+// injectTimeCallback returns a callback that adds the current time, in
+// layout, to the system instruction of each model call. The time is in the
+// client's time zone when the request gave one, and local time otherwise.
+// Unlike .Now in the instruction template, it is never out of date.
+func injectTimeCallback(layout string) llmagent.BeforeModelCallback {
+	return func(ctx agent.CallbackContext, req *model.LLMRequest) (*model.LLMResponse, error) {
+		now := time.Now()
+		if loc, ok := ctx.Value(timezoneKey{}).(*time.Location); ok && loc != nil {
+			now = now.In(loc)
+		}
+		var cfg genai.GenerateContentConfig
+		if req.Config != nil {
+			cfg = *req.Config
+		}
+		var si genai.Content
+		if cfg.SystemInstruction != nil {
+			si = *cfg.SystemInstruction
+		}
+		si.Parts = append(append([]*genai.Part(nil), si.Parts...), genai.NewPartFromText("The current time is "+now.Format(layout)+"."))
+		cfg.SystemInstruction = &si
+		req.Config = &cfg
+		return nil, nil
+	}
+}
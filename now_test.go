@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+)
+
+// injectedTime returns the time that injectTimeCallback added to req, or
+// "" if there is none.
+func injectedTime(req *model.LLMRequest) string {
+	if req.Config == nil || req.Config.SystemInstruction == nil {
+		return ""
+	}
+	for _, p := range req.Config.SystemInstruction.Parts {
+		if s, ok := strings.CutPrefix(p.Text, "The current time is "); ok {
+			return strings.TrimSuffix(s, ".")
+		}
+	}
+	return ""
+}
+
+func TestChatInjectTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		inject   bool
+		timezone string
+		status   int
+		want     string // time zone abbreviation of the injected time
+	}{
+		{"time zone", true, "Asia/Tokyo", http.StatusOK, "JST"},
+		{"UTC", true, "UTC", http.StatusOK, "UTC"},
+		{"unknown time zone", true, "Mars/Olympus_Mons", http.StatusBadRequest, ""},
+		{"disabled", false, "Asia/Tokyo", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("ok")}}
+			cfg, _ := testConfig(t, "-dry-run", "-time-format", "MST")
+			sessionService := session.InMemoryService()
+			var beforeModel []llmagent.BeforeModelCallback
+			if tt.inject {
+				beforeModel = append(beforeModel, injectTimeCallback(cfg.TimeFormat))
+			}
+			run := newTestRunner(t, cfg, sessionService, llm, beforeModel...)
+			chat := chatHandler(cfg, run, sessionService, nil, nil, nil, nil, nil)
+			req := httptest.NewRequest("GET", "/?node_id=n1&msg=hi", nil)
+			req.Header.Set(timezoneHeader, tt.timezone)
+			rec := httptest.NewRecorder()
+			chat.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				if code := decodeError(t, rec); code != "invalid_timezone" {
+					t.Errorf("code = %q, want invalid_timezone", code)
+				}
+				if len(llm.calls) != 0 {
+					t.Errorf("model called %d times", len(llm.calls))
+				}
+				return
+			}
+			if got := injectedTime(llm.calls[0]); got != tt.want {
+				t.Errorf("injected time = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestChatInjectTimeUpdates checks that each request is told the time it
+// was made, not the time of the first.
+func TestChatInjectTimeUpdates(t *testing.T) {
+	llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("ok"), textReply("ok")}}
+	cfg, _ := testConfig(t, "-dry-run", "-time-format", time.RFC3339Nano)
+	sessionService := session.InMemoryService()
+	chat := chatHandler(cfg, newTestRunner(t, cfg, sessionService, llm, injectTimeCallback(cfg.TimeFormat)), sessionService, nil, nil, nil, nil, nil)
+	before := time.Now()
+	for range 2 {
+		rec := httptest.NewRecorder()
+		chat.ServeHTTP(rec, httptest.NewRequest("GET", "/?node_id=n1&msg=hi", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	var times []time.Time
+	for i, call := range llm.calls {
+		tm, err := time.Parse(time.RFC3339Nano, injectedTime(call))
+		if err != nil {
+			t.Fatalf("call %d: %v", i+1, err)
+		}
+		times = append(times, tm)
+	}
+	if len(times) != 2 || times[0].Before(before) || !times[1].After(times[0]) {
+		t.Errorf("injected times %v, want two increasing times after %v", times, before)
+	}
+}
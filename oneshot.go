@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/runner"
+	"google.golang.org/genai"
+)
+
+// runOneshot sends the whole of in as a single prompt and writes the reply
+// to out.
+func runOneshot(ctx context.Context, run *runner.Runner, in io.Reader, out io.Writer, filter *replyFilter) error {
+	b, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	msg := strings.TrimSpace(string(b))
+	if msg == "" {
+		return errors.New("no prompt on stdin")
+	}
+
+	const id = "oneshot"
+	rep, err := collectReply(run.Run(ctx, id, id, genai.NewContentFromText(msg, genai.RoleUser), agent.RunConfig{}))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, filter.apply(rep))
+	return err
+}
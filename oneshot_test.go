@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+func TestRunOneshot(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		reply   fakeReply
+		want    string
+		wantErr bool
+	}{
+		{"reply", "  what is 2+2?\n", textReply("4"), "4\n", false},
+		{"filtered", "hi", textReply("  hello  "), "hello\n", false},
+		{"empty stdin", " \n", textReply("unused"), "", true},
+		{"model error", "hi", fakeReply{err: genai.APIError{Code: 500}}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := &fakeModel{name: "fake", replies: []fakeReply{tt.reply}}
+			cfg, _ := testConfig(t, "-dry-run")
+			run := newTestRunner(t, cfg, session.InMemoryService(), llm)
+
+			var out strings.Builder
+			err := runOneshot(t.Context(), run, strings.NewReader(tt.in), &out, &cfg.Filter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+			if tt.want != "" {
+				if got := lastUserText(llm.calls[0].Contents); got != strings.TrimSpace(tt.in) {
+					t.Errorf("prompt = %q, want %q", got, strings.TrimSpace(tt.in))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// openaiModel talks to an OpenAI compatible chat completions API, such as
+// a local inference server. Only function tools are supported; Gemini
+// built-in tools like Google Search are not sent.
+type openaiModel struct {
+	name       string
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func newOpenAIModel(name, baseURL, apiKey string, httpClient *http.Client) *openaiModel {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &openaiModel{
+		name:       name,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: httpClient,
+	}
+}
+
+// buildOpenAIModel returns the named model, falling back to the others in
+// order like buildModel.
+func buildOpenAIModel(modelName string, fallbackModels []string, baseURL, apiKey string, httpClient *http.Client) model.LLM {
+	slog.Info("Using OpenAI compatible API", "url", baseURL, "model", modelName)
+	m := model.LLM(newOpenAIModel(modelName, baseURL, apiKey, httpClient))
+	if len(fallbackModels) == 0 {
+		return m
+	}
+	chain := &fallbackModel{models: []model.LLM{m}}
+	for _, name := range fallbackModels {
+		chain.models = append(chain.models, newOpenAIModel(name, baseURL, apiKey, httpClient))
+	}
+	slog.Info("Using fallback models", "model", modelName, "fallback", fallbackModels)
+	return chain
+}
+
+func (m *openaiModel) Name() string {
+	return m.name
+}
+
+// GenerateContent sends the request as a single chat completion. Streaming
+// is not supported, so stream is ignored.
+func (m *openaiModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		resp, err := m.complete(ctx, req)
+		yield(resp, err)
+	}
+}
+
+type openaiMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content,omitempty"`
+	ToolCalls  []openaiToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openaiToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openaiTool struct {
+	Type     string         `json:"type"`
+	Function openaiFunction `json:"function"`
+}
+
+type openaiFunction struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+}
+
+type openaiRequest struct {
+	Model       string          `json:"model"`
+	Messages    []openaiMessage `json:"messages"`
+	Tools       []openaiTool    `json:"tools,omitempty"`
+	Temperature *float32        `json:"temperature,omitempty"`
+	TopP        *float32        `json:"top_p,omitempty"`
+	MaxTokens   int32           `json:"max_tokens,omitempty"`
+	Stop        []string        `json:"stop,omitempty"`
+}
+
+type openaiResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      openaiMessage `json:"message"`
+		FinishReason string        `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int32 `json:"prompt_tokens"`
+		CompletionTokens int32 `json:"completion_tokens"`
+		TotalTokens      int32 `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+func (m *openaiModel) complete(ctx context.Context, req *model.LLMRequest) (*model.LLMResponse, error) {
+	name := req.Model
+	if name == "" {
+		name = m.name
+	}
+	body, err := json.Marshal(toOpenAIRequest(name, req))
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, m.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if m.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+m.apiKey)
+	}
+
+	httpResp, err := m.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		// Report errors as a genai.APIError so that key rotation,
+		// fallback, and the circuit breaker treat them like Gemini's.
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+				Type    string `json:"type"`
+			} `json:"error"`
+		}
+		json.Unmarshal(data, &e)
+		if e.Error.Message == "" {
+			e.Error.Message = strings.TrimSpace(string(data))
+		}
+		return nil, genai.APIError{Code: httpResp.StatusCode, Message: e.Error.Message, Status: e.Error.Type}
+	}
+
+	var resp openaiResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("decoding chat completion: %w", err)
+	}
+	return fromOpenAIResponse(&resp)
+}
+
+// toOpenAIRequest translates the model request. The system instruction
+// becomes a system message, and function calls and responses become tool
+// calls and tool messages.
+func toOpenAIRequest(name string, req *model.LLMRequest) *openaiRequest {
+	out := &openaiRequest{Model: name}
+
+	if cfg := req.Config; cfg != nil {
+		if cfg.SystemInstruction != nil {
+			if s := contentText(cfg.SystemInstruction); s != "" {
+				out.Messages = append(out.Messages, openaiMessage{Role: "system", Content: s})
+			}
+		}
+		out.Temperature = cfg.Temperature
+		out.TopP = cfg.TopP
+		out.MaxTokens = cfg.MaxOutputTokens
+		out.Stop = cfg.StopSequences
+		for _, t := range cfg.Tools {
+			if t == nil {
+				continue
+			}
+			for _, fd := range t.FunctionDeclarations {
+				f := openaiFunction{Name: fd.Name, Description: fd.Description, Parameters: fd.ParametersJsonSchema}
+				if f.Parameters == nil && fd.Parameters != nil {
+					f.Parameters = jsonSchema(fd.Parameters)
+				}
+				out.Tools = append(out.Tools, openaiTool{Type: "function", Function: f})
+			}
+		}
+	}
+
+	for _, c := range req.Contents {
+		if c == nil {
+			continue
+		}
+		msg := openaiMessage{Role: "user"}
+		if c.Role == genai.RoleModel {
+			msg.Role = "assistant"
+		}
+		var results []openaiMessage
+		for _, p := range c.Parts {
+			switch {
+			case p == nil || p.Thought:
+			case p.FunctionCall != nil:
+				var tc openaiToolCall
+				tc.ID = p.FunctionCall.ID
+				tc.Type = "function"
+				tc.Function.Name = p.FunctionCall.Name
+				args, _ := json.Marshal(p.FunctionCall.Args)
+				tc.Function.Arguments = string(args)
+				msg.ToolCalls = append(msg.ToolCalls, tc)
+			case p.FunctionResponse != nil:
+				result, _ := json.Marshal(p.FunctionResponse.Response)
+				results = append(results, openaiMessage{Role: "tool", ToolCallID: p.FunctionResponse.ID, Content: string(result)})
+			default:
+				msg.Content += p.Text
+			}
+		}
+		if msg.Content != "" || len(msg.ToolCalls) > 0 {
+			out.Messages = append(out.Messages, msg)
+		}
+		out.Messages = append(out.Messages, results...)
+	}
+	return out
+}
+
+// fromOpenAIResponse translates the first choice of a chat completion.
+func fromOpenAIResponse(resp *openaiResponse) (*model.LLMResponse, error) {
+	out := &model.LLMResponse{
+		ModelVersion: resp.Model,
+		TurnComplete: true,
+		UsageMetadata: &genai.GenerateContentResponseUsageMetadata{
+			PromptTokenCount:     resp.Usage.PromptTokens,
+			CandidatesTokenCount: resp.Usage.CompletionTokens,
+			TotalTokenCount:      resp.Usage.TotalTokens,
+		},
+	}
+	if len(resp.Choices) == 0 {
+		return out, nil
+	}
+
+	choice := resp.Choices[0]
+	out.Content = &genai.Content{Role: genai.RoleModel}
+	if choice.Message.Content != "" {
+		out.Content.Parts = append(out.Content.Parts, genai.NewPartFromText(choice.Message.Content))
+	}
+	for _, tc := range choice.Message.ToolCalls {
+		var args map[string]any
+		if tc.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+				return nil, fmt.Errorf("decoding arguments for %s: %w", tc.Function.Name, err)
+			}
+		}
+		out.Content.Parts = append(out.Content.Parts, &genai.Part{
+			FunctionCall: &genai.FunctionCall{ID: tc.ID, Name: tc.Function.Name, Args: args},
+		})
+	}
+
+	switch choice.FinishReason {
+	case "length":
+		out.FinishReason = genai.FinishReasonMaxTokens
+	case "content_filter":
+		out.FinishReason = genai.FinishReasonSafety
+	default:
+		out.FinishReason = genai.FinishReasonStop
+	}
+	return out, nil
+}
+
+// contentText joins the text parts of c.
+func contentText(c *genai.Content) string {
+	var parts []string
+	for _, p := range c.Parts {
+		if p != nil && p.Text != "" {
+			parts = append(parts, p.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// jsonSchema converts a Gemini schema to JSON Schema, which uses lower
+// case type names.
+func jsonSchema(s *genai.Schema) map[string]any {
+	out := map[string]any{}
+	if s.Type != "" {
+		out["type"] = strings.ToLower(string(s.Type))
+	}
+	if s.Description != "" {
+		out["description"] = s.Description
+	}
+	if len(s.Enum) > 0 {
+		out["enum"] = s.Enum
+	}
+	if s.Items != nil {
+		out["items"] = jsonSchema(s.Items)
+	}
+	if len(s.Properties) > 0 {
+		props := map[string]any{}
+		for name, p := range s.Properties {
+			props[name] = jsonSchema(p)
+		}
+		out["properties"] = props
+	}
+	if len(s.Required) > 0 {
+		out["required"] = s.Required
+	}
+	return out
+}
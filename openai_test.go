@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// fakeOpenAI is an OpenAI compatible chat completions server. It records
+// the requests it gets and answers each with respond.
+type fakeOpenAI struct {
+	*httptest.Server
+	respond func(req openaiRequest) (int, any)
+
+	mu   sync.Mutex
+	reqs []openaiRequest
+	auth []string
+}
+
+func newFakeOpenAI(t *testing.T, respond func(req openaiRequest) (int, any)) *fakeOpenAI {
+	t.Helper()
+	f := &fakeOpenAI{respond: respond}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		var req openaiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.reqs = append(f.reqs, req)
+		f.auth = append(f.auth, r.Header.Get("Authorization"))
+		f.mu.Unlock()
+		status, body := f.respond(req)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// openaiText is a chat completion holding text.
+func openaiText(text string) map[string]any {
+	return map[string]any{
+		"model": "local-model",
+		"choices": []any{map[string]any{
+			"message":       map[string]any{"role": "assistant", "content": text},
+			"finish_reason": "stop",
+		}},
+		"usage": map[string]any{"prompt_tokens": 7, "completion_tokens": 3, "total_tokens": 10},
+	}
+}
+
+func TestOpenAIModel(t *testing.T) {
+	f := newFakeOpenAI(t, func(req openaiRequest) (int, any) {
+		return http.StatusOK, openaiText("Hello")
+	})
+	m := newOpenAIModel("local-model", f.URL+"/v1/", "sk-test", nil)
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{
+			genai.NewContentFromText("hi", genai.RoleUser),
+			genai.NewContentFromText("hello", genai.RoleModel),
+			genai.NewContentFromText("again", genai.RoleUser),
+		},
+		Config: &genai.GenerateContentConfig{
+			SystemInstruction: genai.NewContentFromText("Be brief.", genai.RoleUser),
+			MaxOutputTokens:   50,
+		},
+	}
+	var got []*model.LLMResponse
+	for resp, err := range m.GenerateContent(t.Context(), req, false) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, resp)
+	}
+
+	if len(f.reqs) != 1 {
+		t.Fatalf("server got %d requests, want 1", len(f.reqs))
+	}
+	sent := f.reqs[0]
+	if sent.Model != "local-model" || sent.MaxTokens != 50 || f.auth[0] != "Bearer sk-test" {
+		t.Errorf("sent model %q, max_tokens %d, auth %q", sent.Model, sent.MaxTokens, f.auth[0])
+	}
+	wantMsgs := []openaiMessage{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "hi"},
+		{Role: "assistant", Content: "hello"},
+		{Role: "user", Content: "again"},
+	}
+	if len(sent.Messages) != len(wantMsgs) {
+		t.Fatalf("sent messages %+v, want %+v", sent.Messages, wantMsgs)
+	}
+	for i, msg := range sent.Messages {
+		if msg.Role != wantMsgs[i].Role || msg.Content != wantMsgs[i].Content {
+			t.Errorf("message %d = %+v, want %+v", i, msg, wantMsgs[i])
+		}
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("got %d responses, want 1", len(got))
+	}
+	resp := got[0]
+	if resp.Content.Parts[0].Text != "Hello" || resp.ModelVersion != "local-model" || resp.FinishReason != genai.FinishReasonStop {
+		t.Errorf("response = %+v", resp)
+	}
+	if u := resp.UsageMetadata; u.PromptTokenCount != 7 || u.CandidatesTokenCount != 3 {
+		t.Errorf("usage = %+v, want 7 prompt and 3 response tokens", u)
+	}
+}
+
+func TestOpenAIModelToolCalls(t *testing.T) {
+	f := newFakeOpenAI(t, func(req openaiRequest) (int, any) {
+		return http.StatusOK, map[string]any{
+			"choices": []any{map[string]any{
+				"message": map[string]any{"role": "assistant", "tool_calls": []any{map[string]any{
+					"id": "call_1", "type": "function",
+					"function": map[string]any{"name": "lookup", "arguments": `{"node":"n1"}`},
+				}}},
+				"finish_reason": "tool_calls",
+			}},
+		}
+	})
+	m := newOpenAIModel("local-model", f.URL+"/v1", "", nil)
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{
+			genai.NewContentFromText("who is n1?", genai.RoleUser),
+			{Role: genai.RoleModel, Parts: []*genai.Part{{FunctionCall: &genai.FunctionCall{ID: "call_0", Name: "lookup", Args: map[string]any{"node": "n0"}}}}},
+			{Role: genai.RoleUser, Parts: []*genai.Part{{FunctionResponse: &genai.FunctionResponse{ID: "call_0", Name: "lookup", Response: map[string]any{"name": "zero"}}}}},
+		},
+		Config: &genai.GenerateContentConfig{Tools: []*genai.Tool{{FunctionDeclarations: []*genai.FunctionDeclaration{{
+			Name:       "lookup",
+			Parameters: &genai.Schema{Type: genai.TypeObject, Properties: map[string]*genai.Schema{"node": {Type: genai.TypeString}}},
+		}}}}},
+	}
+	resp, err := m.complete(t.Context(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := f.reqs[0]
+	if f.auth[0] != "" {
+		t.Errorf("Authorization = %q without a key", f.auth[0])
+	}
+	if len(sent.Tools) != 1 || sent.Tools[0].Function.Name != "lookup" {
+		t.Errorf("tools = %+v", sent.Tools)
+	}
+	if len(sent.Messages) != 3 || len(sent.Messages[1].ToolCalls) != 1 || sent.Messages[1].ToolCalls[0].Function.Arguments != `{"node":"n0"}` ||
+		sent.Messages[2].Role != "tool" || sent.Messages[2].ToolCallID != "call_0" || sent.Messages[2].Content != `{"name":"zero"}` {
+		t.Errorf("messages = %+v", sent.Messages)
+	}
+
+	fc := resp.Content.Parts[0].FunctionCall
+	if fc == nil || fc.ID != "call_1" || fc.Name != "lookup" || fc.Args["node"] != "n1" {
+		t.Errorf("function call = %+v", fc)
+	}
+}
+
+func TestOpenAIModelErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    any
+		wantMsg string
+	}{
+		{"error object", http.StatusServiceUnavailable, map[string]any{"error": map[string]any{"message": "overloaded", "type": "server_error"}}, "overloaded"},
+		{"plain body", http.StatusTooManyRequests, "slow down", `"slow down"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeOpenAI(t, func(req openaiRequest) (int, any) { return tt.status, tt.body })
+			m := newOpenAIModel("local-model", f.URL+"/v1", "", nil)
+			err := drain(m, &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}})
+			var apiErr genai.APIError
+			if !errors.As(err, &apiErr) || apiErr.Code != tt.status || apiErr.Message != tt.wantMsg {
+				t.Errorf("err = %v, want an APIError %d %q", err, tt.status, tt.wantMsg)
+			}
+			if !isUpstreamFailure(err) {
+				t.Errorf("isUpstreamFailure(%v) = false", err)
+			}
+		})
+	}
+}
+
+// TestChatOpenAI sends two messages through the chat handler to a fake
+// OpenAI server.
+func TestChatOpenAI(t *testing.T) {
+	f := newFakeOpenAI(t, func(req openaiRequest) (int, any) {
+		return http.StatusOK, openaiText("reply to " + req.Messages[len(req.Messages)-1].Content)
+	})
+	c := newTestChat(t, newOpenAIModel("local-model", f.URL+"/v1", "", nil))
+	for _, msg := range []string{"one", "two"} {
+		rec := c.get(t, nil, "msg", msg, "node_id", "n1")
+		if rec.Code != http.StatusOK || rec.Body.String() != "reply to "+msg {
+			t.Errorf("%s: got %d %q", msg, rec.Code, rec.Body)
+		}
+	}
+	if n := len(f.reqs[1].Messages); n < 4 {
+		t.Errorf("second request sent %d messages, want the history too", n)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// wrapPromptCallback returns a callback that adds prefix and suffix, each
+// on its own line, around the text of the latest user message sent to the
+// model. Only the request is changed, so the session history keeps the
+// message as typed and earlier turns are not wrapped again.
+func wrapPromptCallback(prefix, suffix string) llmagent.BeforeModelCallback {
+	return func(ctx agent.CallbackContext, req *model.LLMRequest) (*model.LLMResponse, error) {
+		for i := len(req.Contents) - 1; i >= 0; i-- {
+			c := req.Contents[i]
+			if !isTurnStart(c) {
+				continue
+			}
+			req.Contents[i] = wrapText(c, prefix, suffix)
+			break
+		}
+		return nil, nil
+	}
+}
+
+// wrapText returns a copy of c with prefix and suffix added around its
+// last text part.
+func wrapText(c *genai.Content, prefix, suffix string) *genai.Content {
+	wrapped := *c
+	wrapped.Parts = append([]*genai.Part(nil), c.Parts...)
+	for i := len(wrapped.Parts) - 1; i >= 0; i-- {
+		p := wrapped.Parts[i]
+		if p == nil || p.Text == "" || p.Thought {
+			continue
+		}
+		part := *p
+		if prefix != "" {
+			part.Text = prefix + "\n" + part.Text
+		}
+		if suffix != "" {
+			part.Text += "\n" + suffix
+		}
+		wrapped.Parts[i] = &part
+		break
+	}
+	return &wrapped
+}
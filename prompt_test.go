@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+func TestWrapText(t *testing.T) {
+	file := genai.NewPartFromURI("files/abc", "image/png")
+	tests := []struct {
+		name           string
+		parts          []*genai.Part
+		prefix, suffix string
+		want           []string
+	}{
+		{"both", []*genai.Part{{Text: "hi"}}, "P", "S", []string{"P\nhi\nS"}},
+		{"prefix only", []*genai.Part{{Text: "hi"}}, "P", "", []string{"P\nhi"}},
+		{"suffix only", []*genai.Part{{Text: "hi"}}, "", "S", []string{"hi\nS"}},
+		{"last text part", []*genai.Part{{Text: "meta"}, file, {Text: "hi"}}, "P", "S", []string{"meta", "", "P\nhi\nS"}},
+		{"thought skipped", []*genai.Part{{Text: "hi"}, {Text: "hmm", Thought: true}}, "P", "", []string{"P\nhi", "hmm"}},
+		{"no text", []*genai.Part{file}, "P", "S", []string{""}},
+	}
+	for _, tt := range tests {
+		c := &genai.Content{Role: genai.RoleUser, Parts: tt.parts}
+		before := make([]string, len(tt.parts))
+		for i, p := range tt.parts {
+			before[i] = p.Text
+		}
+		got := wrapText(c, tt.prefix, tt.suffix)
+		for i, p := range got.Parts {
+			if p.Text != tt.want[i] {
+				t.Errorf("%s: part %d = %q, want %q", tt.name, i, p.Text, tt.want[i])
+			}
+		}
+		for i, p := range c.Parts {
+			if p.Text != before[i] {
+				t.Errorf("%s: original part %d changed to %q", tt.name, i, p.Text)
+			}
+		}
+	}
+}
+
+// TestChatPromptWrap checks that only the latest message is wrapped, and
+// that the session keeps messages as typed.
+func TestChatPromptWrap(t *testing.T) {
+	llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("ok")}}
+	cfg, _ := testConfig(t, "-dry-run", "-prompt-prefix", "BEGIN", "-prompt-suffix", "END")
+	sessionService := session.InMemoryService()
+	run := newTestRunner(t, cfg, sessionService, llm, wrapPromptCallback(cfg.PromptPrefix, cfg.PromptSuffix))
+	chat := chatHandler(cfg, run, sessionService, nil, nil, nil, nil, nil)
+	for _, msg := range []string{"one", "two"} {
+		rec := httptest.NewRecorder()
+		chat.ServeHTTP(rec, httptest.NewRequest("GET", "/?node_id=n1&msg="+msg, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+	}
+	var texts []string
+	for _, content := range llm.calls[1].Contents {
+		texts = append(texts, content.Parts[0].Text)
+	}
+	want := []string{"one", "ok", "BEGIN\ntwo\nEND"}
+	if len(texts) != len(want) {
+		t.Fatalf("sent %q, want %q", texts, want)
+	}
+	for i := range want {
+		if texts[i] != want[i] {
+			t.Errorf("content %d = %q, want %q", i, texts[i], want[i])
+		}
+	}
+}
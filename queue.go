@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// admissionQueue smooths bursts of chat requests. Requests wait, first in
+// first out, for one of a fixed number of workers. A request is turned
+// away with 503 when the queue is full or it has waited too long.
+type admissionQueue struct {
+	jobs    chan *queuedRequest
+	workers int
+	timeout time.Duration
+	fail    errorFunc
+
+	rejected atomic.Int64
+	timedOut atomic.Int64
+
+	mu        sync.Mutex
+	admitted  int64
+	totalWait time.Duration
+	lastWait  time.Duration
+}
+
+// Values of queuedRequest.state.
+const (
+	queuedWaiting int32 = iota
+	queuedStarted
+	queuedAbandoned
+)
+
+type queuedRequest struct {
+	serve    func()
+	enqueued time.Time
+	state    atomic.Int32
+	done     chan struct{} // closed once serve returns
+}
+
+// newAdmissionQueue starts workers that serve requests from a queue of up
+// to size requests. Requests that are turned away get an error from fail.
+func newAdmissionQueue(workers, size int, timeout time.Duration, fail errorFunc) *admissionQueue {
+	q := &admissionQueue{
+		jobs:    make(chan *queuedRequest, size),
+		workers: workers,
+		timeout: timeout,
+		fail:    fail,
+	}
+	for range workers {
+		go q.work()
+	}
+	return q
+}
+
+func (q *admissionQueue) work() {
+	for job := range q.jobs {
+		// Skip requests whose callers stopped waiting.
+		if !job.state.CompareAndSwap(queuedWaiting, queuedStarted) {
+			continue
+		}
+		q.recordWait(time.Since(job.enqueued))
+		job.serve()
+		close(job.done)
+	}
+}
+
+func (q *admissionQueue) recordWait(d time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.admitted++
+	q.totalWait += d
+	q.lastWait = d
+}
+
+// middleware runs next on one of the queue's workers.
+func (q *admissionQueue) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		job := &queuedRequest{
+			serve:    func() { next.ServeHTTP(w, r) },
+			enqueued: time.Now(),
+			done:     make(chan struct{}),
+		}
+		select {
+		case q.jobs <- job:
+		default:
+			q.rejected.Add(1)
+			w.Header().Set("Retry-After", "5")
+			q.fail(w, http.StatusServiceUnavailable, "queue_full", "the server is busy; try again later")
+			return
+		}
+
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		select {
+		case <-job.done:
+			return
+		case <-timer.C:
+			if job.state.CompareAndSwap(queuedWaiting, queuedAbandoned) {
+				q.timedOut.Add(1)
+				w.Header().Set("Retry-After", "5")
+				q.fail(w, http.StatusServiceUnavailable, "queue_timeout", "timed out waiting for a free worker")
+				return
+			}
+		case <-r.Context().Done():
+			if job.state.CompareAndSwap(queuedWaiting, queuedAbandoned) {
+				return
+			}
+		}
+		// A worker has started the request, so wait for it to finish.
+		<-job.done
+	})
+}
+
+// metrics reports the queue depth and wait times for /metrics.
+func (q *admissionQueue) metrics() any {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var meanWait time.Duration
+	if q.admitted > 0 {
+		meanWait = q.totalWait / time.Duration(q.admitted)
+	}
+	return map[string]any{
+		"depth":        len(q.jobs),
+		"capacity":     cap(q.jobs),
+		"workers":      q.workers,
+		"admitted":     q.admitted,
+		"rejected":     q.rejected.Load(),
+		"timed_out":    q.timedOut.Load(),
+		"last_wait_ms": q.lastWait.Milliseconds(),
+		"mean_wait_ms": meanWait.Milliseconds(),
+	}
+}
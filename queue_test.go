@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedHandler blocks each request until release is closed, recording the
+// order requests were served in.
+type gatedHandler struct {
+	release chan struct{}
+	started chan string
+
+	mu    sync.Mutex
+	order []string
+}
+
+func newGatedHandler() *gatedHandler {
+	return &gatedHandler{release: make(chan struct{}), started: make(chan string, 100)}
+}
+
+func (h *gatedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	h.mu.Lock()
+	h.order = append(h.order, id)
+	h.mu.Unlock()
+	h.started <- id
+	<-h.release
+	w.Write([]byte(id))
+}
+
+// queueDepth waits until q holds depth requests.
+func queueDepth(t *testing.T, q *admissionQueue, depth int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(q.jobs) != depth {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue depth = %d, want %d", len(q.jobs), depth)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdmissionQueueFIFO(t *testing.T) {
+	h := newGatedHandler()
+	q := newAdmissionQueue(1, 10, time.Minute, writeError)
+	handler := q.middleware(h)
+
+	ids := []string{"a", "b", "c", "d", "e"}
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Go(func() {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/?id="+id, nil))
+			if rec.Code != http.StatusOK || rec.Body.String() != id {
+				t.Errorf("%s: got %d %q", id, rec.Code, rec.Body)
+			}
+		})
+		// Let each request reach the worker or the queue before sending
+		// the next, so that the arrival order is known.
+		if i == 0 {
+			<-h.started
+		} else {
+			queueDepth(t, q, i)
+		}
+	}
+	m := q.metrics().(map[string]any)
+	if m["depth"] != len(ids)-1 || m["capacity"] != 10 || m["workers"] != 1 {
+		t.Errorf("metrics while waiting = %v", m)
+	}
+	close(h.release)
+	wg.Wait()
+
+	if !slices.Equal(h.order, ids) {
+		t.Errorf("served %q, want %q", h.order, ids)
+	}
+	m = q.metrics().(map[string]any)
+	if m["depth"] != 0 || m["admitted"] != int64(len(ids)) {
+		t.Errorf("metrics after = %v", m)
+	}
+}
+
+func TestAdmissionQueueRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		timeout  time.Duration
+		wantCode string
+		metric   string
+	}{
+		{"full", 1, time.Minute, "queue_full", "rejected"},
+		{"timeout", 10, 10 * time.Millisecond, "queue_timeout", "timed_out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newGatedHandler()
+			q := newAdmissionQueue(1, tt.size, tt.timeout, writeError)
+			handler := q.middleware(h)
+			defer close(h.release)
+
+			// Keep the only worker busy.
+			go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?id=busy", nil))
+			<-h.started
+			if tt.size == 1 {
+				go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?id=queued", nil))
+				queueDepth(t, q, 1)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/?id=late", nil))
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Fatalf("status = %d, want 503", rec.Code)
+			}
+			if code := decodeError(t, rec); code != tt.wantCode {
+				t.Errorf("code = %q, want %q", code, tt.wantCode)
+			}
+			if got := rec.Header().Get("Retry-After"); got == "" {
+				t.Error("no Retry-After header")
+			}
+			if m := q.metrics().(map[string]any); m[tt.metric] != int64(1) {
+				t.Errorf("metrics %s = %v, want 1", tt.metric, m[tt.metric])
+			}
+		})
+	}
+}
+
+// TestAdmissionQueueAbandoned checks that a request whose client went away
+// while it waited is not served.
+func TestAdmissionQueueAbandoned(t *testing.T) {
+	h := newGatedHandler()
+	q := newAdmissionQueue(1, 10, time.Minute, writeError)
+	handler := q.middleware(h)
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?id=busy", nil))
+	})
+	<-h.started
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?id=gone", nil).WithContext(ctx))
+	}()
+	queueDepth(t, q, 1)
+	cancel()
+	<-done
+
+	rec := httptest.NewRecorder()
+	wg.Go(func() {
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/?id=next", nil))
+	})
+	close(h.release)
+	wg.Wait()
+	if rec.Body.String() != "next" || !slices.Equal(h.order, []string{"busy", "next"}) {
+		t.Errorf("served %q, want busy then next", h.order)
+	}
+}
+
+// TestAdmissionQueueSessionLock checks that requests waiting for their
+// session's lock do not hold a worker, when the lock is taken before the
+// queue as in main.
+func TestAdmissionQueueSessionLock(t *testing.T) {
+	h := newGatedHandler()
+	q := newAdmissionQueue(2, 10, time.Minute, writeError)
+	locks := newSessionLocks()
+	handler := locks.middleware(q.middleware(h))
+
+	var wg sync.WaitGroup
+	for i, id := range []string{"a1", "a2", "a3"} {
+		wg.Go(func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?node_id=a&id="+id, nil))
+		})
+		if i == 0 {
+			<-h.started
+		}
+	}
+	for locks.waiters("a") != 3 {
+		time.Sleep(time.Millisecond)
+	}
+	wg.Go(func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?node_id=b&id=b1", nil))
+	})
+	select {
+	case id := <-h.started:
+		if id != "b1" {
+			t.Errorf("started %q, want b1", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("b1 did not start while session a held the lock")
+	}
+	close(h.release)
+	wg.Wait()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/runner"
+)
+
+// replayResult compares a logged response with the one from the replay.
+type replayResult struct {
+	Session  string `json:"session"`
+	Prompt   string `json:"prompt"`
+	Logged   string `json:"logged"`
+	Replayed string `json:"replayed"`
+	Error    string `json:"error,omitempty"`
+	Changed  bool   `json:"changed"`
+}
+
+// runReplay sends the prompts of a request log through the model again, in
+// order, and writes a JSON line to out for each comparing the logged reply
+// with the new one. Each logged session is replayed in a session of its
+// own, so multi-turn conversations see the same history.
+func runReplay(ctx context.Context, run *runner.Runner, in io.Reader, out io.Writer, filter *replyFilter, metadataTarget string) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1<<20)
+	enc := json.NewEncoder(out)
+
+	var total, changed int
+	for scanner.Scan() {
+		var e requestLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("line %d: %w", total+1, err)
+		}
+		total++
+
+		id := "replay-" + e.Session
+		userContent, stateDelta := userTurn(e.Prompt, e.Metadata, metadataTarget)
+		var opts []runner.RunOption
+		if len(stateDelta) > 0 {
+			opts = append(opts, runner.WithStateDelta(stateDelta))
+		}
+
+		res := replayResult{Session: e.Session, Prompt: e.Prompt, Logged: e.Response}
+		rep, err := collectReply(run.Run(ctx, id, id, userContent, agent.RunConfig{}, opts...))
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			res.Error = err.Error()
+		} else {
+			res.Replayed = filter.apply(rep)
+		}
+		res.Changed = res.Replayed != res.Logged || (res.Error != "") != (e.Error != "")
+		if res.Changed {
+			changed++
+		}
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	slog.Info("replay complete", "requests", total, "changed", changed)
+	return nil
+}
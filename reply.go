@@ -0,0 +1,171 @@
+package main
+
+import (
+	"iter"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// reply is the outcome of running one user message through the agent.
+type reply struct {
+	Text         string
+	ModelVersion string
+	FinishReason genai.FinishReason
+
+	// PromptTokens and ResponseTokens total the usage over every model
+	// call made for the reply.
+	PromptTokens   int64
+	ResponseTokens int64
+
+	// Alternatives holds every usable candidate of the last model call
+	// when more than one was asked for, and FilteredAlternatives the
+	// number of candidates left out.
+	Alternatives         []string
+	FilteredAlternatives int
+}
+
+// promptBlockedError reports that the model refused the prompt itself,
+// before writing any reply, such as for safety. Reason is the block reason
+// from the prompt feedback.
+type promptBlockedError struct {
+	Reason  string
+	Message string
+	Ratings []*genai.SafetyRating
+}
+
+func (e *promptBlockedError) Error() string {
+	if e.Message != "" {
+		return "prompt blocked: " + e.Reason + ": " + e.Message
+	}
+	return "prompt blocked: " + e.Reason
+}
+
+// apiError returns the error to send to the client.
+func (e *promptBlockedError) apiError() *apiError {
+	msg := "the prompt was blocked by the model (" + e.Reason + ")"
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	var ratings []string
+	for _, r := range e.Ratings {
+		if r != nil && (r.Blocked || r.Probability == genai.HarmProbabilityMedium || r.Probability == genai.HarmProbabilityHigh) {
+			ratings = append(ratings, string(r.Category)+"="+string(r.Probability))
+		}
+	}
+	if len(ratings) > 0 {
+		msg += "; ratings: " + strings.Join(ratings, ", ")
+	}
+	return &apiError{http.StatusUnprocessableEntity, "prompt_blocked", msg}
+}
+
+// collectReply drains events from the runner and gathers the text of the
+// reply along with the model that produced it.
+func collectReply(events iter.Seq2[*session.Event, error]) (reply, error) {
+	return streamReply(events, nil)
+}
+
+// streamReply is like collectReply, but when the agent runs in streaming
+// mode it also calls partial, if not nil, with each chunk of text as it
+// arrives. The reply is gathered from the complete events that follow the
+// chunks, so it holds the text once.
+func streamReply(events iter.Seq2[*session.Event, error], partial func(text string) error) (reply, error) {
+	var rep reply
+	for event, err := range events {
+		if err != nil {
+			return rep, err
+		}
+		if event.Partial {
+			// The complete event at the end of a stream does not say
+			// which model wrote it.
+			if event.ModelVersion != "" {
+				rep.ModelVersion = event.ModelVersion
+			}
+			if partial != nil && event.Content != nil {
+				for _, part := range event.Content.Parts {
+					if part.Text != "" && !part.Thought {
+						if err := partial(part.Text); err != nil {
+							return rep, err
+						}
+					}
+				}
+			}
+			continue
+		}
+		if event.ModelVersion != "" {
+			rep.ModelVersion = event.ModelVersion
+		}
+		if event.FinishReason != "" {
+			rep.FinishReason = event.FinishReason
+		}
+		if u := event.UsageMetadata; u != nil {
+			rep.PromptTokens += int64(u.PromptTokenCount)
+			rep.ResponseTokens += int64(u.CandidatesTokenCount)
+		}
+		if a, ok := event.CustomMetadata[alternativesKey].(alternatives); ok {
+			rep.Alternatives, rep.FilteredAlternatives = a.Texts, a.Filtered
+		}
+		if event.ErrorCode != "" && event.FinishReason == "" && event.Content == nil {
+			// Prompt feedback, unlike a candidate that was stopped,
+			// has no finish reason.
+			ratings, _ := event.CustomMetadata[safetyRatingsKey].([]*genai.SafetyRating)
+			return rep, &promptBlockedError{Reason: event.ErrorCode, Message: event.ErrorMessage, Ratings: ratings}
+		}
+		if event.Content != nil {
+			for _, part := range event.Content.Parts {
+				if part.Text != "" && !part.Thought {
+					rep.Text += part.Text
+				}
+			}
+		}
+	}
+	return rep, nil
+}
+
+// replyFilter post-processes reply text before it is sent to a client.
+type replyFilter struct {
+	// strip lists patterns removed from the reply, such as a leading
+	// "Model:" that some models echo.
+	strip []*regexp.Regexp
+	// empty replaces a reply with no text, when set.
+	empty string
+	// trim removes leading and trailing white space, including parts that
+	// are only white space at either end.
+	trim bool
+}
+
+func (f *replyFilter) apply(rep reply) string {
+	text := rep.Text
+	for _, re := range f.strip {
+		text = re.ReplaceAllString(text, "")
+	}
+	if f.trim {
+		text = strings.TrimSpace(text)
+	}
+	if strings.TrimSpace(text) == "" {
+		slog.Warn("model returned an empty reply", "model", rep.ModelVersion, "finish_reason", rep.FinishReason)
+		if f.empty != "" {
+			text = f.empty
+		}
+	}
+	return text
+}
+
+// checkPrompt applies the input size limit and blocklist to msg. It
+// returns nil when msg may be sent to the model.
+func checkPrompt(msg string, maxInput int, blocked blocklist) *apiError {
+	if maxInput > 0 && len(msg) > maxInput {
+		return &apiError{http.StatusRequestEntityTooLarge, "input_too_large", "msg is " + strconv.Itoa(len(msg)) + " bytes; the limit is " + strconv.Itoa(maxInput) + " bytes"}
+	}
+	if re := blocked.match(msg); re != nil {
+		// Log the pattern rather than the prompt so blocked text stays out of the logs.
+		slog.Warn("prompt blocked", "pattern", re.String())
+		return &apiError{http.StatusForbidden, "blocked", "message blocked by content policy"}
+	}
+	return nil
+}
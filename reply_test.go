@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+func TestReplyFilterStrip(t *testing.T) {
+	cfg, _ := testConfig(t, "-strip-patterns", `^(?i)model:\s*`, "-strip-patterns", `\[metadata\][\s\S]*?\[/metadata\]\s*`)
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Model: Hello", "Hello"},
+		{"model:Hello", "Hello"},
+		{"Hello, Model: none", "Hello, Model: none"},
+		{"[metadata]\nhops: 1\n[/metadata]\nHi there", "Hi there"},
+		{"The model: a thing", "The model: a thing"},
+		{"Plain reply", "Plain reply"},
+	}
+	for _, tt := range tests {
+		if got := cfg.Filter.apply(reply{Text: tt.text}); got != tt.want {
+			t.Errorf("apply(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseStripPatternsInvalid(t *testing.T) {
+	if _, err := parseConfig(newTestFlagSet(), []string{"-strip-patterns", "("}); err == nil {
+		t.Error("parseConfig accepted an invalid pattern")
+	}
+}
+
+// TestChatStripAfterJoiningParts strips a prefix that the model split
+// across two parts.
+func TestChatStripAfterJoiningParts(t *testing.T) {
+	llm := &fakeModel{name: "fake", replies: []fakeReply{{resps: []*model.LLMResponse{{
+		Content:      &genai.Content{Role: genai.RoleModel, Parts: []*genai.Part{{Text: "Mod"}, {Text: "el: Hello"}}},
+		FinishReason: genai.FinishReasonStop,
+	}}}}}
+	c := newTestChat(t, llm, "-strip-patterns", `^Model:\s*`)
+	rec := c.get(t, nil, "msg", "hi")
+	if rec.Code != http.StatusOK || rec.Body.String() != "Hello" {
+		t.Errorf("got %d %q, want Hello", rec.Code, rec.Body)
+	}
+}
+
+func TestChatEmptyReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts []*genai.Part
+		args  []string
+		want  string
+	}{
+		{"no parts", nil, []string{"-empty-reply", "Sorry, I have no answer."}, "Sorry, I have no answer."},
+		{"white space", []*genai.Part{{Text: " \n"}}, []string{"-empty-reply", "Sorry, I have no answer."}, "Sorry, I have no answer."},
+		{"thought only", []*genai.Part{{Text: "thinking", Thought: true}}, []string{"-empty-reply", "Sorry, I have no answer."}, "Sorry, I have no answer."},
+		{"stripped to nothing", []*genai.Part{{Text: "Model:"}}, []string{"-empty-reply", "Sorry.", "-strip-patterns", `^Model:`}, "Sorry."},
+		{"unset", nil, nil, ""},
+		{"not empty", []*genai.Part{{Text: "Hello"}}, []string{"-empty-reply", "Sorry."}, "Hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := &fakeModel{name: "fake", replies: []fakeReply{{resps: []*model.LLMResponse{{
+				Content:      &genai.Content{Role: genai.RoleModel, Parts: tt.parts},
+				FinishReason: genai.FinishReasonStop,
+			}}}}}
+			c := newTestChat(t, llm, tt.args...)
+			rec := c.get(t, nil, "msg", "hi")
+			if rec.Code != http.StatusOK || rec.Body.String() != tt.want {
+				t.Errorf("got %d %q, want 200 %q", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatTrimResponse(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts []*genai.Part
+		args  []string
+		want  string
+	}{
+		{"surrounding white space", []*genai.Part{{Text: "  Hello \n"}}, nil, "Hello"},
+		{"white space first part", []*genai.Part{{Text: " \n"}, {Text: "Hello"}}, nil, "Hello"},
+		{"empty first part", []*genai.Part{{Text: ""}, {Text: " Hello"}}, nil, "Hello"},
+		{"white space last part", []*genai.Part{{Text: "Hello"}, {Text: "\n\n"}}, nil, "Hello"},
+		{"inner white space kept", []*genai.Part{{Text: " Hello,"}, {Text: " "}, {Text: "world "}}, nil, "Hello, world"},
+		{"disabled", []*genai.Part{{Text: " \n"}, {Text: "Hello "}}, []string{"-trim-response=false"}, " \nHello "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := &fakeModel{name: "fake", replies: []fakeReply{{resps: []*model.LLMResponse{{
+				Content:      &genai.Content{Role: genai.RoleModel, Parts: tt.parts},
+				FinishReason: genai.FinishReasonStop,
+			}}}}}
+			c := newTestChat(t, llm, tt.args...)
+			rec := c.get(t, nil, "msg", "hi")
+			if rec.Code != http.StatusOK || rec.Body.String() != tt.want {
+				t.Errorf("got %d %q, want 200 %q", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// requestLogBuffer is how many entries may be waiting to be written before
+// new ones are dropped.
+const requestLogBuffer = 1024
+
+// requestLogEntry is one line of the request log.
+type requestLogEntry struct {
+	Time     time.Time      `json:"time"`
+	Session  string         `json:"session"`
+	Prompt   string         `json:"prompt"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+	Response string         `json:"response,omitempty"`
+	Model    string         `json:"model,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// requestLog appends entries to a JSONL file from a background goroutine,
+// so logging never blocks a request. If the writer falls behind, entries
+// are dropped and counted.
+type requestLog struct {
+	entries chan requestLogEntry
+	done    chan struct{}
+	redact  bool
+	dropped atomic.Int64
+}
+
+// redactedKeys are metadata keys that identify a person. With redaction on,
+// they and the session are replaced with a hash, which keeps the turns of a
+// session together for replay.
+var redactedKeys = []string{"node_id", "short_name", "long_name"}
+
+func openRequestLog(path string, redact bool) (*requestLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l := &requestLog{
+		entries: make(chan requestLogEntry, requestLogBuffer),
+		done:    make(chan struct{}),
+		redact:  redact,
+	}
+	go func() {
+		defer close(l.done)
+		w := bufio.NewWriter(f)
+		enc := json.NewEncoder(w)
+		for e := range l.entries {
+			if err := enc.Encode(e); err != nil {
+				slog.Error("failed to write request log", "error", err)
+			}
+			if len(l.entries) == 0 {
+				w.Flush()
+			}
+		}
+		w.Flush()
+		if err := f.Close(); err != nil {
+			slog.Error("failed to close request log", "error", err)
+		}
+	}()
+	return l, nil
+}
+
+// log queues e to be written.
+func (l *requestLog) log(e requestLogEntry) {
+	if l.redact {
+		e.Session = redactValue(e.Session)
+		if len(e.Metadata) > 0 {
+			e.Metadata = maps.Clone(e.Metadata)
+			for _, k := range redactedKeys {
+				if v, ok := e.Metadata[k]; ok {
+					e.Metadata[k] = redactValue(v)
+				}
+			}
+		}
+	}
+	select {
+	case l.entries <- e:
+	default:
+		if l.dropped.Add(1) == 1 {
+			slog.Warn("request log is behind, dropping entries")
+		}
+	}
+}
+
+// Close writes any queued entries and closes the file.
+func (l *requestLog) Close() {
+	close(l.entries)
+	<-l.done
+	if n := l.dropped.Load(); n > 0 {
+		slog.Warn("request log entries dropped", "count", n)
+	}
+}
+
+func redactValue(v any) string {
+	sum := sha256.Sum256(fmt.Append(nil, v))
+	return hex.EncodeToString(sum[:8])
+}
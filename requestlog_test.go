@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/adk/session"
+)
+
+// readRequestLog returns the entries in the request log at path.
+func readRequestLog(t *testing.T, path string) []requestLogEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []requestLogEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e requestLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestChatRequestLog(t *testing.T) {
+	tests := []struct {
+		redact      bool
+		session     string
+		nodeID      any
+		wantEntries int
+	}{
+		{false, "n1", "n1", 2},
+		{true, redactValue("n1"), redactValue("n1"), 2},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "requests.jsonl")
+		reqLog, err := openRequestLog(path, tt.redact)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, _ := testConfig(t, "-dry-run", "-metadata-mode", "every-turn")
+		sessionService := session.InMemoryService()
+		chat := chatHandler(cfg, newTestRunner(t, cfg, sessionService, echoModel{}), sessionService, nil, nil, nil, reqLog, nil)
+		for _, msg := range []string{"one", "two"} {
+			rec := httptest.NewRecorder()
+			chat.ServeHTTP(rec, httptest.NewRequest("GET", "/?node_id=n1&hops=2&msg="+msg, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+		}
+		reqLog.Close()
+
+		entries := readRequestLog(t, path)
+		if len(entries) != tt.wantEntries {
+			t.Fatalf("redact %v: logged %d entries, want %d", tt.redact, len(entries), tt.wantEntries)
+		}
+		for i, e := range entries {
+			prompt := []string{"one", "two"}[i]
+			if e.Prompt != prompt || !strings.HasPrefix(e.Response, "[dry-run] "+prompt) || e.Error != "" || e.Time.IsZero() {
+				t.Errorf("redact %v: entry %d = %+v", tt.redact, i, e)
+			}
+			if e.Session != tt.session || e.Metadata["node_id"] != tt.nodeID || e.Metadata["hops"] != 2.0 {
+				t.Errorf("redact %v: entry %d session %q, metadata %v", tt.redact, i, e.Session, e.Metadata)
+			}
+		}
+	}
+}
+
+func TestRunReplay(t *testing.T) {
+	lines := []requestLogEntry{
+		{Session: "a", Prompt: "one", Response: "[dry-run] one"},
+		{Session: "a", Prompt: "two", Response: "something else"},
+		{Session: "b", Prompt: "three", Error: "upstream_error"},
+	}
+	var in strings.Builder
+	enc := json.NewEncoder(&in)
+	for _, e := range lines {
+		enc.Encode(e)
+	}
+
+	llm := &fakeModel{name: "fake", replies: []fakeReply{textReply("[dry-run] one"), textReply("[dry-run] two"), textReply("three")}}
+	cfg, _ := testConfig(t, "-dry-run")
+	run := newTestRunner(t, cfg, session.InMemoryService(), llm)
+	var out strings.Builder
+	if err := runReplay(t.Context(), run, strings.NewReader(in.String()), &out, &cfg.Filter, cfg.MetadataTarget); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []replayResult{
+		{Session: "a", Prompt: "one", Logged: "[dry-run] one", Replayed: "[dry-run] one"},
+		{Session: "a", Prompt: "two", Logged: "something else", Replayed: "[dry-run] two", Changed: true},
+		{Session: "b", Prompt: "three", Replayed: "three", Changed: true},
+	}
+	dec := json.NewDecoder(strings.NewReader(out.String()))
+	for i, w := range want {
+		var got replayResult
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("result %d: %v", i, err)
+		}
+		if got != w {
+			t.Errorf("result %d = %+v, want %+v", i, got, w)
+		}
+	}
+	if dec.More() {
+		t.Error("more results than entries")
+	}
+	// The turns of session a are replayed in one session.
+	if n := len(llm.calls[1].Contents); n != 3 {
+		t.Errorf("second turn of a sent %d contents, want 3", n)
+	}
+	if n := len(llm.calls[2].Contents); n != 1 {
+		t.Errorf("first turn of b sent %d contents, want 1", n)
+	}
+
+	if err := runReplay(t.Context(), run, strings.NewReader("not json\n"), &out, &cfg.Filter, cfg.MetadataTarget); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("replaying a bad line: err = %v, want one naming line 1", err)
+	}
+}
+
+// TestChatRequestLogEmptyReply checks that an empty reply is filtered, and
+// warned about, once for both the log and the client.
+func TestChatRequestLogEmptyReply(t *testing.T) {
+	logs := captureLogs(t)
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	reqLog, err := openRequestLog(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _ := testConfig(t, "-dry-run", "-empty-reply", "Sorry.")
+	sessionService := session.InMemoryService()
+	llm := &fakeModel{name: "fake", replies: []fakeReply{partsReply()}}
+	chat := chatHandler(cfg, newTestRunner(t, cfg, sessionService, llm), sessionService, nil, nil, nil, reqLog, nil)
+	rec := httptest.NewRecorder()
+	chat.ServeHTTP(rec, httptest.NewRequest("GET", "/?node_id=n1&msg=hi", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "Sorry." {
+		t.Fatalf("got %d %q, want Sorry.", rec.Code, rec.Body)
+	}
+	reqLog.Close()
+	if entries := readRequestLog(t, path); len(entries) != 1 || entries[0].Response != "Sorry." {
+		t.Errorf("entries = %+v, want one with the reply sent", entries)
+	}
+	if n := strings.Count(logs.String(), "model returned an empty reply"); n != 1 {
+		t.Errorf("empty reply warned about %d times, want 1", n)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"iter"
+	"log/slog"
+	"strings"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// retryEmptyModel sends a request again when the model answers with no
+// content. Retrying at the model layer means the empty answer never
+// reaches the session, so history is not affected. Streaming requests are
+// passed through unchanged.
+type retryEmptyModel struct {
+	model.LLM
+	retries int
+}
+
+func (m *retryEmptyModel) GetGoogleLLMVariant() genai.Backend {
+	return googleLLMVariant(m.LLM)
+}
+
+func (m *retryEmptyModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	if stream {
+		return m.LLM.GenerateContent(ctx, req, stream)
+	}
+	return func(yield func(*model.LLMResponse, error) bool) {
+		for attempt := 0; ; attempt++ {
+			var resps []*model.LLMResponse
+			for resp, err := range m.LLM.GenerateContent(ctx, req, false) {
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				resps = append(resps, resp)
+			}
+			if attempt < m.retries && isEmptyResponse(resps) {
+				slog.Warn("model returned an empty response, retrying", "model", m.Name(), "attempt", attempt+1)
+				continue
+			}
+			for _, resp := range resps {
+				if !yield(resp, nil) {
+					return
+				}
+			}
+			return
+		}
+	}
+}
+
+// isEmptyResponse reports whether resps carry no text, other than thoughts,
+// function calls, or other content. Responses with an error code, such as a safety block,
+// are not considered empty since retrying will not help.
+func isEmptyResponse(resps []*model.LLMResponse) bool {
+	for _, resp := range resps {
+		if resp == nil {
+			continue
+		}
+		if resp.ErrorCode != "" {
+			return false
+		}
+		if resp.Content == nil {
+			continue
+		}
+		for _, p := range resp.Content.Parts {
+			if p == nil {
+				continue
+			}
+			if (!p.Thought && strings.TrimSpace(p.Text) != "") || p.FunctionCall != nil || p.FunctionResponse != nil ||
+				p.InlineData != nil || p.FileData != nil || p.ExecutableCode != nil || p.CodeExecutionResult != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// partsReply is a fakeReply with a single response holding parts.
+func partsReply(parts ...*genai.Part) fakeReply {
+	return fakeReply{resps: []*model.LLMResponse{{
+		Content:      &genai.Content{Role: genai.RoleModel, Parts: parts},
+		FinishReason: genai.FinishReasonStop,
+	}}}
+}
+
+func TestIsEmptyResponse(t *testing.T) {
+	tests := []struct {
+		name  string
+		resps []*model.LLMResponse
+		want  bool
+	}{
+		{"none", nil, true},
+		{"nil response", []*model.LLMResponse{nil}, true},
+		{"no content", []*model.LLMResponse{{FinishReason: genai.FinishReasonStop}}, true},
+		{"no parts", partsReply().resps, true},
+		{"white space", partsReply(&genai.Part{Text: " \n"}).resps, true},
+		{"thought only", partsReply(&genai.Part{Text: "hmm", Thought: true}).resps, true},
+		{"text", partsReply(&genai.Part{Text: "hi"}).resps, false},
+		{"function call", partsReply(&genai.Part{FunctionCall: &genai.FunctionCall{Name: "f"}}).resps, false},
+		{"inline data", partsReply(&genai.Part{InlineData: &genai.Blob{MIMEType: "image/png"}}).resps, false},
+		{"error code", []*model.LLMResponse{{ErrorCode: "SAFETY"}}, false},
+		{"text in a later response", append(partsReply().resps, partsReply(&genai.Part{Text: "hi"}).resps...), false},
+	}
+	for _, tt := range tests {
+		if got := isEmptyResponse(tt.resps); got != tt.want {
+			t.Errorf("%s: isEmptyResponse() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryEmptyModel(t *testing.T) {
+	empty := partsReply()
+	tests := []struct {
+		name      string
+		retries   int
+		replies   []fakeReply
+		stream    bool
+		wantCalls int
+		wantText  string
+		wantErr   bool
+	}{
+		{"not empty", 2, []fakeReply{textReply("hi")}, false, 1, "hi", false},
+		{"empty then text", 2, []fakeReply{empty, textReply("hi")}, false, 2, "hi", false},
+		{"always empty", 2, []fakeReply{empty}, false, 3, "", false},
+		{"no retries", 0, []fakeReply{empty, textReply("hi")}, false, 1, "", false},
+		{"error not retried", 2, []fakeReply{{err: errors.New("boom")}, textReply("hi")}, false, 1, "", true},
+		{"streaming passed through", 2, []fakeReply{empty, textReply("hi")}, true, 1, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := &fakeModel{name: "fake", replies: tt.replies}
+			m := &retryEmptyModel{LLM: llm, retries: tt.retries}
+			var text string
+			var err error
+			for resp, e := range m.GenerateContent(t.Context(), &model.LLMRequest{}, tt.stream) {
+				if e != nil {
+					err = e
+					continue
+				}
+				if resp.Content != nil {
+					for _, p := range resp.Content.Parts {
+						text += p.Text
+					}
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got := llm.numCalls(); got != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", got, tt.wantCalls)
+			}
+			if text != tt.wantText {
+				t.Errorf("text = %q, want %q", text, tt.wantText)
+			}
+		})
+	}
+}
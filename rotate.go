@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// rotatingModel spreads requests for one model across several API keys.
+// Each key has its own model.LLM. Requests go round-robin to keys that are
+// not cooling down after a 429. Conversation history lives in the ADK
+// session rather than in a genai chat, so a session need not stick to a key.
+type rotatingModel struct {
+	models   []model.LLM
+	cooldown time.Duration
+
+	mu        sync.Mutex
+	next      int
+	coolUntil []time.Time
+}
+
+func newRotatingModel(models []model.LLM, cooldown time.Duration) *rotatingModel {
+	return &rotatingModel{
+		models:    models,
+		cooldown:  cooldown,
+		coolUntil: make([]time.Time, len(models)),
+	}
+}
+
+func (m *rotatingModel) Name() string {
+	return m.models[0].Name()
+}
+
+func (m *rotatingModel) GetGoogleLLMVariant() genai.Backend {
+	return googleLLMVariant(m.models[0])
+}
+
+// order returns the key indexes to try, starting with the next key in
+// rotation and putting keys that are cooling down last.
+func (m *rotatingModel) order() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	start := m.next
+	m.next = (m.next + 1) % len(m.models)
+
+	var ready, cooling []int
+	for i := range m.models {
+		k := (start + i) % len(m.models)
+		if now.Before(m.coolUntil[k]) {
+			cooling = append(cooling, k)
+		} else {
+			ready = append(ready, k)
+		}
+	}
+	return append(ready, cooling...)
+}
+
+func (m *rotatingModel) markCooling(k int) {
+	m.mu.Lock()
+	m.coolUntil[k] = time.Now().Add(m.cooldown)
+	m.mu.Unlock()
+}
+
+func (m *rotatingModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	return func(yield func(*model.LLMResponse, error) bool) {
+		keys := m.order()
+		for i, k := range keys {
+			var limited error
+			started := false
+			for resp, err := range m.models[k].GenerateContent(ctx, req, stream) {
+				if err != nil && !started && i < len(keys)-1 && isRateLimited(err) {
+					limited = err
+					break
+				}
+				started = true
+				if !yield(resp, err) {
+					return
+				}
+			}
+			if limited == nil {
+				return
+			}
+			m.markCooling(k)
+			slog.Warn("API key rate limited, rotating", "model", m.Name(), "key", k, "cooldown", m.cooldown)
+		}
+	}
+}
+
+// isRateLimited reports whether err is a 429 from the Gemini API.
+func isRateLimited(err error) bool {
+	var apiErr genai.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+func TestRotatingModel(t *testing.T) {
+	ok := textReply("ok")
+	limited := fakeReply{err: genai.APIError{Code: http.StatusTooManyRequests}}
+	tests := []struct {
+		name      string
+		replies   [3][]fakeReply // per key
+		calls     int
+		wantCalls [3]int
+		wantErr   bool
+	}{
+		{"round robin", [3][]fakeReply{{ok}, {ok}, {ok}}, 6, [3]int{2, 2, 2}, false},
+		// The first call moves on from key 0 to key 1. Key 0 then cools
+		// down, so the fourth call, whose turn it is, goes to key 1.
+		{"rate limited key cools down", [3][]fakeReply{{limited}, {ok}, {ok}}, 4, [3]int{1, 3, 1}, false},
+		{"all keys limited", [3][]fakeReply{{limited}, {limited}, {limited}}, 1, [3]int{1, 1, 1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var keys [3]*fakeModel
+			var models []model.LLM
+			for i := range keys {
+				keys[i] = &fakeModel{name: "m", replies: tt.replies[i]}
+				models = append(models, keys[i])
+			}
+			m := newRotatingModel(models, time.Hour)
+			var err error
+			for range tt.calls {
+				err = drain(m, &model.LLMRequest{})
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("last error = %v, want error %v", err, tt.wantErr)
+			}
+			if calls := [3]int{keys[0].numCalls(), keys[1].numCalls(), keys[2].numCalls()}; calls != tt.wantCalls {
+				t.Errorf("calls per key = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestBuildModelRotatesKeys(t *testing.T) {
+	g := newFakeGemini(t)
+	g.respond = func(req fakeGeminiRequest) (int, any) {
+		if req.Key == "k2" {
+			return http.StatusTooManyRequests, geminiError(http.StatusTooManyRequests, "RESOURCE_EXHAUSTED")
+		}
+		return http.StatusOK, geminiText("ok")
+	}
+	cfg, _ := testConfig(t, "-api-keys", "k1,k2,k3", "-base-url", g.URL+"/")
+	llm, err := buildModel(t.Context(), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 4 {
+		if err := drain(llm, geminiRequest("hi")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var keys []string
+	for _, c := range g.calls() {
+		keys = append(keys, c.Key)
+	}
+	// k2 is tried once, then skipped while it cools down.
+	want := []string{"k1", "k2", "k3", "k3", "k1"}
+	if len(keys) != len(want) {
+		t.Fatalf("keys used = %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("keys used = %v, want %v", keys, want)
+		}
+	}
+}
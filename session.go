@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/agent/llmagent"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+)
+
+// defaultSession is shared by requests that carry no channel, node, or
+// cookie.
+const defaultSession = "default"
+
+// reservedSessionPrefixes are used for internal sessions, such as batch
+// items and replays, and may not be chosen by clients.
+var reservedSessionPrefixes = []string{"batch-", "replay-", "oneshot"}
+
+var errInvalidSession = errors.New("invalid session")
+
+// sessionModelKey is the session state key naming a model to use instead
+// of the default for that session.
+const sessionModelKey = "model"
+
+// sessionKey returns the session for a message. The channel is used, or
+// the node for direct messages ("DM"), then the browser session cookie,
+// and finally a shared default session. Values are trimmed, and blank
+// values are skipped. A reserved key is an error wrapping
+// errInvalidSession.
+func sessionKey(channel, nodeID, cookie string) (string, error) {
+	key := strings.TrimSpace(channel)
+	if key == "DM" || key == "" {
+		key = strings.TrimSpace(nodeID)
+	}
+	if key == "" {
+		key = strings.TrimSpace(cookie)
+	}
+	if key == "" {
+		return defaultSession, nil
+	}
+	for _, prefix := range reservedSessionPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return "", fmt.Errorf("%w: %q is reserved", errInvalidSession, key)
+		}
+	}
+	return key, nil
+}
+
+// requestSessionID returns the session for a request from its channel and
+// node_id query parameters and the session cookie verified by
+// sessionCookies. See sessionKey.
+func requestSessionID(r *http.Request) (string, error) {
+	q := r.URL.Query()
+	return sessionKey(q.Get("channel"), q.Get("node_id"), cookieSession(r.Context()))
+}
+
+// sessionModelCallback returns a callback that sends requests to the
+// session's model, if one was chosen.
+func sessionModelCallback() llmagent.BeforeModelCallback {
+	return func(ctx agent.CallbackContext, req *model.LLMRequest) (*model.LLMResponse, error) {
+		if v, err := ctx.ReadonlyState().Get(sessionModelKey); err == nil {
+			if name, ok := v.(string); ok && name != "" {
+				req.Model = name
+			}
+		}
+		return nil, nil
+	}
+}
+
+// sessionLocks serializes the messages sent to each session, so that
+// concurrent requests for one session reach the model, and its history, in
+// the order they arrived. Waiters are served first come, first served.
+type sessionLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sessionLock
+}
+
+type sessionLock struct {
+	sem  chan struct{} // holds a value while the session is busy
+	refs int           // holders and waiters
+}
+
+func newSessionLocks() *sessionLocks {
+	return &sessionLocks{locks: make(map[string]*sessionLock)}
+}
+
+// lock waits for the session to be free and returns a function that frees
+// it. It gives up with the context's error if ctx is done first.
+func (l *sessionLocks) lock(ctx context.Context, sessionID string) (unlock func(), err error) {
+	l.mu.Lock()
+	sl, ok := l.locks[sessionID]
+	if !ok {
+		sl = &sessionLock{sem: make(chan struct{}, 1)}
+		l.locks[sessionID] = sl
+	}
+	sl.refs++
+	l.mu.Unlock()
+
+	release := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if sl.refs--; sl.refs == 0 {
+			delete(l.locks, sessionID)
+		}
+	}
+
+	select {
+	case sl.sem <- struct{}{}:
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+	return func() {
+		<-sl.sem
+		release()
+	}, nil
+}
+
+// middleware runs next while holding the lock of the request's session.
+// Requests for an invalid session are passed to next unlocked, for it to
+// reject.
+func (l *sessionLocks) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionID, err := requestSessionID(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		unlock, err := l.lock(r.Context(), sessionID)
+		if err != nil {
+			slog.Debug("request canceled", "session_id", sessionID, "error", err)
+			return
+		}
+		defer unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forgettingService calls forget with the ID of each session it deletes,
+// so that state kept outside the session goes with it.
+type forgettingService struct {
+	session.Service
+	forget func(sessionID string)
+}
+
+func (s *forgettingService) Delete(ctx context.Context, req *session.DeleteRequest) error {
+	if err := s.Service.Delete(ctx, req); err != nil {
+		return err
+	}
+	s.forget(req.SessionID)
+	return nil
+}
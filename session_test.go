@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+func TestSessionKey(t *testing.T) {
+	tests := []struct {
+		name                    string
+		channel, nodeID, cookie string
+		want                    string
+		wantErr                 bool
+	}{
+		{"channel", "2", "n1", "c1", "2", false},
+		{"direct message", "DM", "n1", "c1", "n1", false},
+		{"empty channel", "", "n1", "c1", "n1", false},
+		{"blank channel", "  ", "n1", "c1", "n1", false},
+		{"trimmed", " 2 ", "", "", "2", false},
+		{"cookie", "", " ", "c1", "c1", false},
+		{"direct message without node", "DM", "", "c1", "c1", false},
+		{"default", "", "", "", defaultSession, false},
+		{"spaces and punctuation", "Long Fast!", "", "", "Long Fast!", false},
+		{"hash", "#general", "", "", "#general", false},
+		{"non-ASCII letters", "Café Ñandú", "", "", "Café Ñandú", false},
+		{"non-Latin script", "メッシュ", "", "", "メッシュ", false},
+		{"reserved batch", "batch-1", "", "", "", true},
+		{"reserved replay", "", "replay-n1", "", "", true},
+		{"reserved oneshot", "oneshot", "", "", "", true},
+		{"reserved cookie", "", "", "batch-x", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sessionKey(tt.channel, tt.nodeID, tt.cookie)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidSession) {
+					t.Errorf("sessionKey() = %q, %v; want errInvalidSession", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("sessionKey() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// waiters returns how many requests hold or wait for the session's lock.
+func (l *sessionLocks) waiters(sessionID string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if sl, ok := l.locks[sessionID]; ok {
+		return sl.refs
+	}
+	return 0
+}
+
+// TestSessionLocksOrder sends messages to one session while the first is
+// still being answered. They must reach the model, and the history, in
+// the order they arrived.
+func TestSessionLocksOrder(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	var mu sync.Mutex
+	var order []string
+	llm := funcModel(func(req *model.LLMRequest) fakeReply {
+		msg := lastUserText(req.Contents)
+		mu.Lock()
+		first := len(order) == 0
+		order = append(order, msg)
+		mu.Unlock()
+		if first {
+			started <- struct{}{}
+			<-release
+		}
+		return textReply("re " + msg)
+	})
+	cfg, _ := testConfig(t, "-dry-run")
+	sessionService := session.InMemoryService()
+	locks := newSessionLocks()
+	chat := locks.middleware(chatHandler(cfg, newTestRunner(t, cfg, sessionService, llm), sessionService, nil, nil, nil, nil, nil))
+
+	msgs := []string{"m0", "m1", "m2", "m3", "m4", "m5"}
+	var wg sync.WaitGroup
+	for i, msg := range msgs {
+		wg.Go(func() {
+			rec := httptest.NewRecorder()
+			chat.ServeHTTP(rec, httptest.NewRequest("GET", "/?node_id=n1&msg="+msg, nil))
+			if rec.Code != http.StatusOK || rec.Body.String() != "re "+msg {
+				t.Errorf("%s: got %d %q", msg, rec.Code, rec.Body)
+			}
+		})
+		// Wait for this request to be answered or queued before sending
+		// the next, so that the arrival order is known.
+		if i == 0 {
+			<-started
+		}
+		for locks.waiters("n1") != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	close(release)
+	wg.Wait()
+
+	if !slices.Equal(order, msgs) {
+		t.Errorf("model saw %q, want %q", order, msgs)
+	}
+	resp, err := sessionService.Get(t.Context(), sessionGetRequest("n1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var history []string
+	for event := range resp.Session.Events().All() {
+		if event.Content != nil && event.Content.Role == genai.RoleUser {
+			history = append(history, event.Content.Parts[0].Text)
+		}
+	}
+	if !slices.Equal(history, msgs) {
+		t.Errorf("history = %q, want %q", history, msgs)
+	}
+	if n := locks.waiters("n1"); n != 0 {
+		t.Errorf("%d lock holders left", n)
+	}
+}
+
+func TestSessionLocksCancel(t *testing.T) {
+	locks := newSessionLocks()
+	unlock, err := locks.lock(t.Context(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another session is not held up.
+	unlockB, err := locks.lock(t.Context(), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockB()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := locks.lock(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("lock of a held session = %v, want DeadlineExceeded", err)
+	}
+	unlock()
+	if len(locks.locks) != 0 {
+		t.Errorf("locks left = %v, want none", locks.locks)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// spillStore keeps replies that are too large to send inline in temporary
+// files, served from /media/{id} until they expire.
+type spillStore struct {
+	dir  string
+	root *os.Root
+	ttl  time.Duration
+}
+
+func newSpillStore(ttl time.Duration) (*spillStore, error) {
+	dir, err := os.MkdirTemp("", "chatty-spill-")
+	if err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &spillStore{dir: dir, root: root, ttl: ttl}, nil
+}
+
+// save writes text to a new file and returns its id. The file is removed
+// after the store's TTL.
+func (s *spillStore) save(text string) (string, error) {
+	id := rand.Text()
+	if err := s.root.WriteFile(id, []byte(text), 0o600); err != nil {
+		return "", err
+	}
+	time.AfterFunc(s.ttl, func() {
+		if err := s.root.Remove(id); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("failed to remove spilled reply", "id", id, "error", err)
+		}
+	})
+	return id, nil
+}
+
+// handler serves a spilled reply by id.
+func (s *spillStore) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, err := s.root.Open(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, "not_found", "no such reply, or it has expired")
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			writeError(w, http.StatusNotFound, "not_found", "no such reply, or it has expired")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.ServeContent(w, r, "", fi.ModTime(), f)
+	}
+}
+
+// Close removes all spilled replies.
+func (s *spillStore) Close() {
+	s.root.Close()
+	if err := os.RemoveAll(s.dir); err != nil {
+		slog.Warn("failed to remove spilled replies", "dir", s.dir, "error", err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/session"
+)
+
+func TestChatSpill(t *testing.T) {
+	spill, err := newSpillStore(50 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spill.Close()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /media/{id}", spill.handler())
+
+	long := strings.Repeat("long reply ", 10)
+	llm := &fakeModel{name: "fake", replies: []fakeReply{textReply(long), textReply("short")}}
+	cfg, _ := testConfig(t, "-dry-run", "-spill-threshold", "20")
+	sessionService := session.InMemoryService()
+	mux.Handle("GET /{$}", chatHandler(cfg, newTestRunner(t, cfg, sessionService, llm), sessionService, nil, nil, spill, nil, nil))
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		return rec
+	}
+
+	rec := get("/?msg=one&node_id=n1")
+	path := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.HasPrefix(path, "/media/") || rec.Header().Get("Location") != path {
+		t.Fatalf("large reply: got %d %q with Location %q, want a /media/ link", rec.Code, path, rec.Header().Get("Location"))
+	}
+	if rec := get("/?msg=two&node_id=n1"); rec.Body.String() != "short" {
+		t.Errorf("small reply = %q, want it inline", rec.Body)
+	}
+
+	rec = get(path)
+	if rec.Code != http.StatusOK || rec.Body.String() != strings.TrimSpace(long) || rec.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("GET %s: got %d %q %q", path, rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	for _, target := range []string{"/media/missing", "/media/..%2Fetc%2Fpasswd"} {
+		if rec := get(target); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status = %d, want 404", target, rec.Code)
+		}
+	}
+
+	// The reply expires after the TTL.
+	deadline := time.Now().Add(5 * time.Second)
+	for get(path).Code != http.StatusNotFound {
+		if time.Now().After(deadline) {
+			t.Fatalf("GET %s still served after the TTL", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSpillStoreClose(t *testing.T) {
+	spill, err := newSpillStore(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := spill.save("reply"); err != nil {
+		t.Fatal(err)
+	}
+	spill.Close()
+	if _, err := os.Stat(spill.dir); !os.IsNotExist(err) {
+		t.Errorf("spill directory after Close: %v, want it removed", err)
+	}
+}
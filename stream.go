@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// sseWriter sends a reply as server-sent events. Each chunk of text is a
+// "chunk" event as the model produces it, and the finished reply is a
+// "done" event. Headers are sent with the first event, so a failure before
+// then is reported as an ordinary error response.
+//
+// With a timeout, each event moves the response's write deadline to
+// timeout from then, so a stream that keeps sending is not cut off by the
+// server's WriteTimeout.
+type sseWriter struct {
+	w        http.ResponseWriter
+	fallback func(w http.ResponseWriter, status int, code, message string)
+	timeout  time.Duration
+	started  bool
+}
+
+// send writes one event with v as its JSON data.
+func (s *sseWriter) send(event string, v any) error {
+	if !s.started {
+		s.started = true
+		s.w.Header().Set("Content-Type", "text/event-stream")
+		// Keep any -response-cache-control value already set.
+		if s.w.Header().Get("Cache-Control") == "" {
+			s.w.Header().Set("Cache-Control", "no-cache")
+		}
+		s.w.WriteHeader(http.StatusOK)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	rc := http.NewResponseController(s.w)
+	if s.timeout > 0 {
+		if err := rc.SetWriteDeadline(time.Now().Add(s.timeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
+
+// partial sends a chunk of text.
+func (s *sseWriter) partial(text string) error {
+	return s.send("chunk", struct {
+		Text string `json:"text"`
+	}{text})
+}
+
+// done sends the finished reply.
+func (s *sseWriter) done(text, modelVersion string) error {
+	return s.send("done", struct {
+		Text  string `json:"text"`
+		Model string `json:"model,omitempty"`
+	}{text, modelVersion})
+}
+
+// fail reports an error as an "error" event once the stream has started,
+// and as an ordinary error response before then.
+func (s *sseWriter) fail(w http.ResponseWriter, status int, code, message string) {
+	if !s.started {
+		s.fallback(w, status, code, message)
+		return
+	}
+	s.send("error", apiError{Status: status, Code: code, Message: message})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+func TestSSEWriterEvents(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sse := &sseWriter{w: rec, fallback: writeError}
+	sse.partial("Hel")
+	sse.partial("lo")
+	sse.done("Hello", "m1")
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	want := "event: chunk\ndata: {\"text\":\"Hel\"}\n\n" +
+		"event: chunk\ndata: {\"text\":\"lo\"}\n\n" +
+		"event: done\ndata: {\"text\":\"Hello\",\"model\":\"m1\"}\n\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
+
+func TestSSEWriterFail(t *testing.T) {
+	tests := []struct {
+		name       string
+		started    bool
+		wantStatus int
+		wantBody   string
+	}{
+		{"before first event", false, http.StatusBadGateway, `"code":"upstream"`},
+		{"after first event", true, http.StatusOK, "event: error\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			sse := &sseWriter{w: rec, fallback: writeError}
+			if tt.started {
+				sse.partial("x")
+			}
+			sse.fail(rec, http.StatusBadGateway, "upstream", "failed")
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body %q does not contain %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+// TestSSEWriterExtendsWriteDeadline streams for longer than the server's
+// WriteTimeout, sending an event more often than that.
+func TestSSEWriterExtendsWriteDeadline(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sse := &sseWriter{w: w, fallback: writeError, timeout: timeout}
+		for range 5 {
+			time.Sleep(timeout / 2)
+			if err := sse.partial("x"); err != nil {
+				return
+			}
+		}
+		sse.done("xxxxx", "")
+	}))
+	srv.Config.WriteTimeout = timeout
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var events []string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		if event, ok := strings.CutPrefix(sc.Text(), "event: "); ok {
+			events = append(events, event)
+		}
+	}
+	if len(events) != 6 || events[5] != "done" {
+		t.Errorf("got events %v, want 5 chunks and done", events)
+	}
+}
+
+// TestChatStream sends the model's partial responses as chunk events.
+func TestChatStream(t *testing.T) {
+	part := func(text string, partial bool) *model.LLMResponse {
+		return &model.LLMResponse{Content: genai.NewContentFromText(text, genai.RoleModel), Partial: partial}
+	}
+	tests := []struct {
+		name   string
+		reply  fakeReply
+		status int
+		body   string
+	}{
+		{"chunks", fakeReply{resps: []*model.LLMResponse{part("Hel", true), part("lo", true), part("Hello", false)}}, http.StatusOK,
+			"event: chunk\ndata: {\"text\":\"Hel\"}\n\n" +
+				"event: chunk\ndata: {\"text\":\"lo\"}\n\n" +
+				"event: done\ndata: {\"text\":\"Hello\"}\n\n"},
+		{"error after a chunk", fakeReply{resps: []*model.LLMResponse{part("Hel", true)}, err: errors.New("boom")}, http.StatusOK,
+			"event: chunk\ndata: {\"text\":\"Hel\"}\n\n" +
+				"event: error\ndata: {\"code\":\"upstream_error\",\"message\":\"failed to get response from AI\"}\n\n"},
+		{"error before a chunk", fakeReply{err: errors.New("boom")}, http.StatusInternalServerError,
+			`{"error":{"code":"upstream_error","message":"failed to get response from AI"}}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestChat(t, &fakeModel{name: "fake", replies: []fakeReply{tt.reply}})
+			rec := c.get(t, http.Header{"Accept": {"text/event-stream"}}, "msg", "hi")
+			if rec.Code != tt.status || rec.Body.String() != tt.body {
+				t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body, tt.status, tt.body)
+			}
+		})
+	}
+}
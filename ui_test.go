@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestUI loads the page, then sends a message the way the page does: with
+// the cookie it was given, asking for server-sent events.
+func TestUI(t *testing.T) {
+	signer := &cookieSigner{secrets: [][]byte{[]byte("secret")}}
+	ui := sessionCookies(signer, true, uiHandler())
+	c := newTestChat(t, echoModel{})
+	chat := sessionCookies(signer, false, c)
+
+	rec := httptest.NewRecorder()
+	ui.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ui/", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("GET /ui/: status = %d, Content-Type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), "text/event-stream") {
+		t.Error("page does not ask for streamed replies")
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie {
+		t.Fatalf("cookies = %v, want a session cookie", cookies)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/?msg=hello", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	chat.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("chat: status = %d, Content-Type = %q: %s", rec.Code, ct, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), "event: done\ndata: {\"text\":\"[dry-run] hello") {
+		t.Errorf("chat body = %q, want a done event with the reply", rec.Body)
+	}
+	if len(rec.Result().Cookies()) != 0 {
+		t.Error("chat issued another cookie")
+	}
+
+	id, _ := signer.verify(cookies[0].Value)
+	if _, err := c.sessionService.Get(t.Context(), sessionGetRequest(id)); err != nil {
+		t.Errorf("no session for the cookie: %v", err)
+	}
+}
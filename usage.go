@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// requestUsage accumulates the tokens used while handling one HTTP
+// request. Handlers add to it and loggingMiddleware reports it.
+type requestUsage struct {
+	promptTokens   atomic.Int64
+	responseTokens atomic.Int64
+}
+
+func (u *requestUsage) add(rep reply) {
+	u.promptTokens.Add(rep.PromptTokens)
+	u.responseTokens.Add(rep.ResponseTokens)
+}
+
+// cost estimates the spend in dollars given per-1K-token prices.
+func (u *requestUsage) cost(priceInput, priceOutput float64) float64 {
+	return float64(u.promptTokens.Load())/1000*priceInput + float64(u.responseTokens.Load())/1000*priceOutput
+}
+
+type usageKey struct{}
+
+// withUsage returns a context carrying a new requestUsage.
+func withUsage(ctx context.Context) (context.Context, *requestUsage) {
+	u := &requestUsage{}
+	return context.WithValue(ctx, usageKey{}, u), u
+}
+
+// recordUsage adds the tokens used by rep to the request's usage, if the
+// context carries one.
+func recordUsage(ctx context.Context, rep reply) {
+	if u, ok := ctx.Value(usageKey{}).(*requestUsage); ok {
+		u.add(rep)
+	}
+}
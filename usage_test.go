@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRequestUsage(t *testing.T) {
+	tests := []struct {
+		replies                 []reply
+		priceInput, priceOutput float64
+		want                    float64
+	}{
+		{nil, 0.1, 0.4, 0},
+		{[]reply{{PromptTokens: 1000, ResponseTokens: 500}}, 0, 0, 0},
+		{[]reply{{PromptTokens: 1000, ResponseTokens: 500}}, 0.1, 0.4, 0.3},
+		{[]reply{{PromptTokens: 200, ResponseTokens: 100}, {PromptTokens: 800, ResponseTokens: 400}}, 0.1, 0.4, 0.3},
+	}
+	for i, tt := range tests {
+		ctx, u := withUsage(context.Background())
+		for _, rep := range tt.replies {
+			recordUsage(ctx, rep)
+		}
+		if got := u.cost(tt.priceInput, tt.priceOutput); got < tt.want-1e-9 || got > tt.want+1e-9 {
+			t.Errorf("%d: cost = %v, want %v", i, got, tt.want)
+		}
+	}
+
+	// Without a requestUsage in the context, recording does nothing.
+	recordUsage(context.Background(), reply{PromptTokens: 1})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestVersionHandler(t *testing.T) {
+	tests := []struct {
+		version, commit string
+	}{
+		{"v1.2.3", "abc123"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			oldVersion, oldCommit := version, commit
+			version, commit = tt.version, tt.commit
+			defer func() { version, commit = oldVersion, oldCommit }()
+
+			rec := httptest.NewRecorder()
+			versionHandler(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			var info buildInfo
+			if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+				t.Fatal(err)
+			}
+			if info.GoVersion != runtime.Version() {
+				t.Errorf("go_version = %q, want %q", info.GoVersion, runtime.Version())
+			}
+			if tt.version != "" && (info.Version != tt.version || info.Commit != tt.commit) {
+				t.Errorf("got %+v, want version %q and commit %q from the link-time variables", info, tt.version, tt.commit)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/adk/model"
+	"google.golang.org/genai"
+)
+
+// warmupTimeout bounds the warm-up request.
+const warmupTimeout = 30 * time.Second
+
+// warmUp sends a tiny request to llm so that connections are open before
+// the first real request, and so that bad keys or model names show up in
+// the log right away.
+func warmUp(ctx context.Context, llm model.LLM) {
+	ctx, cancel := context.WithTimeout(ctx, warmupTimeout)
+	defer cancel()
+
+	start := time.Now()
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText("Reply with OK.", genai.RoleUser)},
+		Config:   &genai.GenerateContentConfig{MaxOutputTokens: 8},
+	}
+	for _, err := range llm.GenerateContent(ctx, req, false) {
+		if err != nil {
+			slog.Error("model warm-up failed", "model", llm.Name(), "duration", time.Since(start), "error", err)
+			return
+		}
+	}
+	slog.Info("model warmed up", "model", llm.Name(), "duration", time.Since(start))
+}
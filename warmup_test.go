@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWarmUp(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   fakeReply
+		wantLog string
+	}{
+		{"ok", textReply("OK"), `"msg":"model warmed up"`},
+		{"error", fakeReply{err: errors.New("bad key")}, `"msg":"model warm-up failed"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := &fakeModel{name: "fake", replies: []fakeReply{tt.reply}}
+			logs := captureLogs(t)
+			warmUp(t.Context(), llm)
+			if n := llm.numCalls(); n != 1 {
+				t.Fatalf("model called %d times, want 1", n)
+			}
+			if cfg := llm.calls[0].Config; cfg == nil || cfg.MaxOutputTokens == 0 {
+				t.Error("warm-up request does not limit its output")
+			}
+			if !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("logs = %s, want %s", logs, tt.wantLog)
+			}
+		})
+	}
+}
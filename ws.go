@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/adk/agent"
+	"google.golang.org/adk/runner"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+const (
+	// wsPongWait is how long to wait for any message, including a pong,
+	// before treating the connection as dead.
+	wsPongWait = 60 * time.Second
+	// wsPingPeriod must be less than wsPongWait.
+	wsPingPeriod = wsPongWait * 9 / 10
+	wsWriteWait  = 10 * time.Second
+)
+
+// wsMessage is sent to the client for each message it sends.
+type wsMessage struct {
+	Partial string    `json:"partial,omitempty"`
+	Text    string    `json:"text,omitempty"`
+	Model   string    `json:"model,omitempty"`
+	Error   *apiError `json:"error,omitempty"`
+}
+
+// wsHandler upgrades to a WebSocket bound to the request's session. Each
+// text message from the client is a prompt, and each reply is sent back as
+// a JSON wsMessage. With stream=1 in the query, chunks of the reply are
+// sent as partial messages while the model writes it.
+func wsHandler(cfg *config, run *runner.Runner, sessionService session.Service, blocked blocklist, locks *sessionLocks, backoff *sessionBackoff) http.Handler {
+	upgrader := websocket.Upgrader{}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionID, err := requestSessionID(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_session", err.Error())
+			return
+		}
+		loc, err := requestTimezone(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_timezone", err.Error())
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has already sent an error response.
+			return
+		}
+		defer conn.Close()
+
+		// Cancel any model call in progress once the socket goes away.
+		ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+		defer cancel()
+		if loc != nil {
+			ctx = withTimezone(ctx, loc)
+		}
+
+		var writeMu sync.Mutex
+		write := func(msg wsMessage) error {
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			return conn.WriteJSON(msg)
+		}
+
+		// A message over the input limit closes the socket rather than
+		// being read into memory.
+		if cfg.MaxInput > 0 {
+			conn.SetReadLimit(int64(cfg.MaxInput))
+		}
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+
+		go func() {
+			ticker := time.NewTicker(wsPingPeriod)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					writeMu.Lock()
+					err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+					writeMu.Unlock()
+					if err != nil {
+						cancel()
+						return
+					}
+				}
+			}
+		}()
+
+		var runConfig agent.RunConfig
+		var partial func(string) error
+		if r.URL.Query().Get("stream") == "1" {
+			runConfig.StreamingMode = agent.StreamingModeSSE
+			partial = func(text string) error {
+				return write(wsMessage{Partial: text})
+			}
+		}
+
+		slog.Info("websocket opened", "session_id", sessionID)
+		defer slog.Info("websocket closed", "session_id", sessionID)
+
+		// Read and handle messages one at a time so replies keep the order
+		// of the prompts.
+		for {
+			typ, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(wsPongWait))
+			if typ != websocket.TextMessage {
+				continue
+			}
+
+			msg := string(data)
+			if msg == "" {
+				write(wsMessage{Error: &apiError{http.StatusBadRequest, "missing_msg", "message is empty"}})
+				continue
+			}
+			if e := checkPrompt(msg, cfg.MaxInput, blocked); e != nil {
+				write(wsMessage{Error: e})
+				continue
+			}
+			if backoff != nil {
+				if d := backoff.wait(sessionID); d > 0 {
+					write(wsMessage{Error: &apiError{http.StatusTooManyRequests, "session_backoff", "requests for this session have failed repeatedly; try again in " + d.Round(time.Second).String()}})
+					continue
+				}
+			}
+			unlock, err := locks.lock(ctx, sessionID)
+			if err != nil {
+				return
+			}
+			if err := checkBudget(ctx, sessionService, sessionID, cfg.MaxTurns, cfg.TokenBudget); err != nil {
+				unlock()
+				write(wsMessage{Error: &apiError{http.StatusTooManyRequests, "budget_exceeded", err.Error()}})
+				continue
+			}
+
+			content := genai.NewContentFromText(msg, genai.RoleUser)
+			rep, err := streamReply(run.Run(ctx, sessionID, sessionID, content, runConfig), partial)
+			unlock()
+			var blockedErr *promptBlockedError
+			switch {
+			case errors.Is(err, context.Canceled):
+				return
+			case errors.Is(err, errCircuitOpen):
+				err = write(wsMessage{Error: &apiError{http.StatusServiceUnavailable, "unavailable", "the AI service is temporarily unavailable"}})
+			case errors.As(err, &blockedErr):
+				err = write(wsMessage{Error: blockedErr.apiError()})
+			case err != nil:
+				if backoff != nil {
+					backoff.failure(sessionID)
+				}
+				slog.Error("failed to get response from AI", "session_id", sessionID, "error", err)
+				err = write(wsMessage{Error: &apiError{http.StatusInternalServerError, "upstream_error", "failed to get response from AI"}})
+			default:
+				if backoff != nil {
+					backoff.success(sessionID)
+				}
+				err = write(wsMessage{Text: cfg.Filter.apply(rep), Model: rep.ModelVersion})
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/adk/model"
+	"google.golang.org/adk/session"
+	"google.golang.org/genai"
+)
+
+// dialTestWS starts wsHandler answered by llm and connects to it with
+// query.
+func dialTestWS(t *testing.T, llm model.LLM, query string, args ...string) *websocket.Conn {
+	t.Helper()
+	cfg, _ := testConfig(t, append([]string{"-dry-run"}, args...)...)
+	var blocked blocklist
+	if cfg.BlockFile != "" {
+		var err error
+		if blocked, err = loadBlocklist(cfg.BlockFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sessionService := session.InMemoryService()
+	run := newTestRunner(t, cfg, sessionService, llm)
+	srv := httptest.NewServer(wsHandler(cfg, run, sessionService, blocked, newSessionLocks(), nil))
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// exchange sends msg and reads messages until one that is not partial.
+func exchange(t *testing.T, conn *websocket.Conn, msg string) []wsMessage {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	var got []wsMessage
+	for {
+		var m wsMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, m)
+		if m.Partial == "" {
+			return got
+		}
+	}
+}
+
+func TestWSMessages(t *testing.T) {
+	conn := dialTestWS(t, echoModel{}, "node_id=n1", "-blocklist-file", writeBlocklist(t, "forbidden"))
+	tests := []struct {
+		msg       string
+		wantText  string
+		wantError string
+	}{
+		{"hello", "[dry-run] hello", ""},
+		{"", "", "missing_msg"},
+		{"say forbidden things", "", "blocked"},
+		{"again", "[dry-run] again", ""},
+	}
+	for _, tt := range tests {
+		got := exchange(t, conn, tt.msg)
+		m := got[len(got)-1]
+		if m.Text != tt.wantText {
+			t.Errorf("%q: text = %q, want %q", tt.msg, m.Text, tt.wantText)
+		}
+		var code string
+		if m.Error != nil {
+			code = m.Error.Code
+		}
+		if code != tt.wantError {
+			t.Errorf("%q: error = %q, want %q", tt.msg, code, tt.wantError)
+		}
+	}
+}
+
+func TestWSInvalidSession(t *testing.T) {
+	cfg, _ := testConfig(t, "-dry-run", "-max-input-bytes", "0")
+	sessionService := session.InMemoryService()
+	run := newTestRunner(t, cfg, sessionService, echoModel{})
+	srv := httptest.NewServer(wsHandler(cfg, run, sessionService, nil, newSessionLocks(), nil))
+	defer srv.Close()
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/?node_id=batch-1", nil)
+	if err == nil || resp == nil || resp.StatusCode != 400 {
+		t.Errorf("dial with an invalid session: err = %v, response %v; want 400", err, resp)
+	}
+}
+
+func TestWSReadLimit(t *testing.T) {
+	conn := dialTestWS(t, echoModel{}, "node_id=n1", "-max-input-bytes", "16")
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 64))); err != nil {
+		t.Fatal(err)
+	}
+	var m wsMessage
+	err := conn.ReadJSON(&m)
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("read after an oversized message: %+v, %v; want close %d", m, err, websocket.CloseMessageTooBig)
+	}
+}
+
+func TestWSStream(t *testing.T) {
+	part := func(text string, partial bool) *model.LLMResponse {
+		return &model.LLMResponse{Content: genai.NewContentFromText(text, genai.RoleModel), Partial: partial}
+	}
+	llm := &fakeModel{name: "fake", replies: []fakeReply{{resps: []*model.LLMResponse{
+		part("Hel", true), part("lo", true), part("Hello", false),
+	}}}}
+	conn := dialTestWS(t, llm, "node_id=n1&stream=1")
+	got := exchange(t, conn, "hi")
+	var partials []string
+	for _, m := range got[:len(got)-1] {
+		partials = append(partials, m.Partial)
+	}
+	if strings.Join(partials, "|") != "Hel|lo" || got[len(got)-1].Text != "Hello" {
+		t.Errorf("messages = %+v, want partials Hel, lo then Hello", got)
+	}
+}